@@ -0,0 +1,60 @@
+// metafake levanta un Graph API falso para desarrollar el flujo de comprobantes sin conexión a Meta.
+// Cada archivo de -dir se publica como media con ID igual al nombre sin extensión.
+//
+//	go run ./cmd/metafake -addr :9090 -dir ./fixtures/receipts -token dev
+//	META_GRAPH_BASE_URL=http://localhost:9090 META_ACCESS_TOKEN=dev go run ./cmd/server
+package main
+
+import (
+	"flag"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/usuario/valpago-backend/internal/meta/metafake"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	dir := flag.String("dir", "", "directory with media files (ID = file name without extension)")
+	token := flag.String("token", "", "expected bearer token (empty accepts any)")
+	version := flag.String("version", "v18.0", "Graph API version prefix")
+	baseURL := flag.String("base-url", "", "public base URL used in media URLs (default: request host)")
+	flag.Parse()
+
+	srv := metafake.New(*token)
+	srv.Version = *version
+	if *baseURL != "" {
+		srv.SetBaseURL(*baseURL)
+	}
+
+	if *dir != "" {
+		entries, err := os.ReadDir(*dir)
+		if err != nil {
+			log.Fatalf("reading %s: %v", *dir, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(*dir, entry.Name()))
+			if err != nil {
+				log.Fatalf("reading %s: %v", entry.Name(), err)
+			}
+			ext := filepath.Ext(entry.Name())
+			contentType := mime.TypeByExtension(ext)
+			if contentType == "" {
+				contentType = http.DetectContentType(data)
+			}
+			id := strings.TrimSuffix(entry.Name(), ext)
+			srv.AddMedia(id, data, contentType)
+			log.Printf("media %s (%s, %d bytes)", id, contentType, len(data))
+		}
+	}
+
+	log.Printf("Fake Meta Graph API listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
@@ -13,8 +19,11 @@ import (
 
 	"github.com/usuario/valpago-backend/internal/config"
 	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/jwtkeys"
+	"github.com/usuario/valpago-backend/internal/outbox"
 	"github.com/usuario/valpago-backend/internal/routes"
 	"github.com/usuario/valpago-backend/internal/sse"
+	"github.com/usuario/valpago-backend/internal/storage"
 	"github.com/usuario/valpago-backend/internal/worker"
 )
 
@@ -24,6 +33,15 @@ func main() {
 	if err := config.Load(); err != nil {
 		log.Fatalf("config error: %v", err)
 	}
+	// -worker=false cuando el consumidor del stream corre aparte (cmd/worker) y escala por su cuenta
+	embeddedWorker := flag.Bool("worker", config.C.EmbeddedWorker, "run the Redis stream worker in this process (env EMBEDDED_WORKER)")
+	flag.Parse()
+	// Sin worker embebido los comprobantes los guarda otro proceso
+	if !*embeddedWorker {
+		if err := storage.RequireShared(); err != nil {
+			log.Fatalf("storage error: %v", err)
+		}
+	}
 
 	log.Printf("Connecting to MongoDB: %s", config.C.MongoURI)
 	if err := db.ConnectMongo(config.C.MongoURI, config.C.MongoDB); err != nil {
@@ -31,6 +49,25 @@ func main() {
 	}
 	log.Println("MongoDB connected successfully")
 
+	if err := routes.EnsureIndexes(context.Background()); err != nil {
+		log.Printf("mongo indexes warning: %v", err)
+	}
+
+	if err := jwtkeys.Init(context.Background()); err != nil {
+		log.Fatalf("jwt keys error: %v", err)
+	}
+	// Procesos de fondo: se cancelan después de drenar las peticiones HTTP
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	// Contexto de los handlers del worker: se cancela al vencer el plazo de apagado
+	drainCtx, stopDrain := context.WithCancel(context.Background())
+	defer stopDrain()
+	go jwtkeys.StartRotation(bgCtx)
+
+	if err := storage.Init(); err != nil {
+		log.Fatalf("storage error: %v", err)
+	}
+
 	log.Printf("Connecting to Redis: %s", config.C.RedisURL)
 	if err := db.ConnectRedis(config.C.RedisURL); err != nil {
 		log.Printf("redis warning: %v (continuing without Redis)", err)
@@ -38,22 +75,41 @@ func main() {
 		log.Println("Redis connected successfully")
 	}
 
-	go worker.Start()
+	var background sync.WaitGroup
+	if *embeddedWorker {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			worker.Start(bgCtx, drainCtx)
+		}()
+	} else {
+		log.Println("Embedded worker disabled")
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		outbox.Run(bgCtx, func(ctx context.Context) {
+			routes.EnqueuePendingNotifications(ctx)
+			routes.RepublishPendingEvents(ctx)
+		})
+	}()
 
 	e := echo.New()
 	e.HideBanner = true
+	// Las conexiones SSE no terminan solas: se cierran en cuanto empieza el apagado
+	e.Server.RegisterOnShutdown(sse.Shutdown)
 	e.Use(middleware.Recover())
 	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{config.C.AllowedOrigins},
-		AllowHeaders: []string{echo.HeaderContentType, echo.HeaderAuthorization, config.C.APIKeyHeader},
-		AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodOptions},
+		AllowHeaders: []string{echo.HeaderContentType, echo.HeaderAuthorization, config.C.APIKeyHeader, "Idempotency-Key"},
+		AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
 	}))
 
 	e.GET("/health", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
 	routes.Register(e)
-	sse.Register(e)
+	sse.Register(e, routes.Authorize)
 
 	port := config.C.ServerPort
 	if p := os.Getenv("SERVER_PORT"); p != "" {
@@ -63,7 +119,51 @@ func main() {
 	}
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("listening on %s", addr)
-	if err := e.Start(addr); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("server error: %v", err)
+	go func() {
+		if err := e.Start(addr); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("server error: %v", err)
+		}
+	}()
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	<-signals.Done()
+	log.Println("shutting down...")
+
+	// 1. Dejar de aceptar peticiones y esperar las que están en curso
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.C.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+	context.AfterFunc(ctx, stopDrain)
+	if err := e.Shutdown(ctx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+
+	// 2. Detener worker y dispatcher; el worker termina el lote que ya leyó
+	stopBackground()
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("shutdown deadline reached before the worker finished; pending entries will be reclaimed")
+		// Los handlers ya están cancelados: darles un momento para soltar las conexiones
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	// 3. Cerrar conexiones
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelClose()
+	if err := db.DisconnectMongo(closeCtx); err != nil {
+		log.Printf("mongo disconnect: %v", err)
+	}
+	if err := db.CloseRedis(); err != nil {
+		log.Printf("redis close: %v", err)
 	}
+	log.Println("server stopped")
 }
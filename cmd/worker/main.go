@@ -0,0 +1,87 @@
+// worker consume el stream de procesamiento de transacciones sin levantar la API, para escalarlo
+// aparte del servidor (que se arranca con -worker=false o EMBEDDED_WORKER=false). Con
+// STORAGE_BACKEND=local ambos deben montar el mismo STORAGE_LOCAL_DIR y declarar STORAGE_LOCAL_SHARED=true.
+//
+//	POD_NAME=worker-0 go run ./cmd/worker
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/storage"
+	"github.com/usuario/valpago-backend/internal/worker"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	if err := config.Load(); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+
+	log.Printf("Connecting to MongoDB: %s", config.C.MongoURI)
+	if err := db.ConnectMongo(config.C.MongoURI, config.C.MongoDB); err != nil {
+		log.Fatalf("mongo error: %v", err)
+	}
+	log.Println("MongoDB connected successfully")
+
+	// Los comprobantes descargados se guardan en el storage, que la API tiene que poder leer
+	if err := storage.RequireShared(); err != nil {
+		log.Fatalf("storage error: %v", err)
+	}
+	if err := storage.Init(); err != nil {
+		log.Fatalf("storage error: %v", err)
+	}
+
+	log.Printf("Connecting to Redis: %s", config.C.RedisURL)
+	if err := db.ConnectRedis(config.C.RedisURL); err != nil {
+		log.Fatalf("redis error: %v", err)
+	}
+	log.Println("Redis connected successfully")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Contexto de los handlers: se cancela al vencer el plazo de apagado
+	drainCtx, stopDrain := context.WithCancel(context.Background())
+	defer stopDrain()
+
+	done := make(chan struct{})
+	go func() {
+		worker.Start(ctx, drainCtx)
+		close(done)
+	}()
+
+	<-ctx.Done()
+	log.Println("shutting down...")
+
+	// El worker termina el lote que ya leyó; lo que quede pendiente lo reclama otro consumidor
+	select {
+	case <-done:
+	case <-time.After(time.Duration(config.C.ShutdownTimeoutSeconds) * time.Second):
+		log.Println("shutdown deadline reached before the worker finished; pending entries will be reclaimed")
+		stopDrain()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.DisconnectMongo(closeCtx); err != nil {
+		log.Printf("mongo disconnect: %v", err)
+	}
+	if err := db.CloseRedis(); err != nil {
+		log.Printf("redis close: %v", err)
+	}
+	log.Println("worker stopped")
+}
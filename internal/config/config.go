@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
 )
@@ -14,12 +15,94 @@ type Config struct {
 	RedisNotificationsStream string
 	RedisGroup               string
 	RedisConsumer            string
+	AppEnv                   string
 	JWTSecret                string
-	JWTExpHours              int
+	JWTAlg                   string
+	JWTIssuer                string
+	JWTKeyRotationHours      int
+	JWTKeyGraceHours         int
+	JWTKeyEncryptionSecret   string
+	JWTAccessTTLMinutes      int
+	RefreshTokenTTLHours     int
+	LoginMaxAttempts         int
+	LoginMaxAttemptsIP       int
+	LoginLockoutBaseSeconds  int
+	LoginLockoutMaxSeconds   int
 	APIKeyHeader             string
+	IdempotencyTTLHours      int
+	IdempotencyLockSeconds   int
 	ServerPort               int
 	AllowedOrigins           string
+	// Graceful shutdown
+	ShutdownTimeoutSeconds int
+	SSERetryMillis         int
+	// Passwords
+	PasswordMinLength       int
+	PasswordResetTTLMinutes int
+	// Duplicate receipts
+	DuplicateWindowHours        int
+	DuplicateDateToleranceHours int
+	ReceiptHashMaxDistance      int
+	// Notifications
+	NotifyDefaultLocale   string
+	WhatsappWebhookURL    string
+	WhatsappPhoneNumberID string
+	TelegramBotToken      string
+	TelegramAPIURL        string
+	SMTPHost              string
+	SMTPPort              int
+	SMTPUser              string
+	SMTPPassword          string
+	SMTPFrom              string
+	// Notification outbox (dispatcher)
+	NotifyMaxAttempts       int
+	NotifyBackoffSeconds    int
+	NotifyBackoffMaxSeconds int
+	NotifyPollSeconds       int
+	// Merchant webhooks (signed)
+	WebhookTimeoutSeconds   int
+	WebhookSecretGraceHours int
+	// WhatsApp Cloud API inbound webhook
+	WhatsappVerifyToken   string
+	WhatsappAppSecret     string
+	WhatsappDefaultUserID string
+	// Receipt storage
+	StorageBackend       string
+	StorageLocalDir      string
+	StorageLocalShared   bool
+	StorageSigningSecret string
+	StorageURLTTLMinutes int
+	PublicBaseURL        string
+	S3Endpoint           string
+	S3Region             string
+	S3Bucket             string
+	S3AccessKey          string
+	S3SecretKey          string
+	S3PathStyle          bool
+	// Receipt fetching (worker)
+	ReceiptFetchMaxAttempts    int
+	ReceiptFetchBackoffSeconds int
+	ReceiptFetchMaxSeconds     int
+	// Worker stream recovery (XAUTOCLAIM + DLQ)
+	WorkerMaxDeliveries        int
+	WorkerClaimIntervalSeconds int
+	WorkerClaimMinIdleSeconds  int
+	WorkerConsumerIdleMinutes  int
+	// Worker pool
+	WorkerConcurrency int
+	WorkerQueueSize   int
+	// Worker process
+	EmbeddedWorker bool
+	PodName        string
+	// Stream retention
+	NotificationsStreamMaxLen    int
+	DLQMaxLen                    int
+	StreamRetentionHours         int
+	StreamArchiveIntervalSeconds int
 	// External services
+	MetaGraphBaseURL   string
+	MetaGraphVersion   string
+	MetaTimeoutSeconds int
 	BearerTokenMeta    string
 	SupabaseProject    string
 	SupabaseBucket     string
@@ -29,6 +112,14 @@ type Config struct {
 
 var C Config
 
+// DevJWTSecret es el secreto HS256 por defecto; solo se acepta con APP_ENV=development
+const DevJWTSecret = "dev_secret_change_me"
+
+// IsDevelopment indica si la app corre en modo desarrollo (APP_ENV=development)
+func (c Config) IsDevelopment() bool {
+	return c.AppEnv == "development"
+}
+
 func Load() error {
 	C.MongoURI = os.Getenv("MONGODB_URI")
 	if C.MongoURI == "" {
@@ -40,13 +131,120 @@ func Load() error {
 	C.RedisNotificationsStream = getenv("REDIS_NOTIFICATIONS_STREAM", "valpago:notifications")
 	C.RedisGroup = getenv("REDIS_CONSUMER_GROUP", "valpago:cg")
 	C.RedisConsumer = getenv("REDIS_CONSUMER_NAME", "worker-1")
-	C.JWTSecret = getenv("JWT_SECRET", "dev_secret_change_me")
-	C.JWTExpHours = getenvInt("JWT_EXP_HOURS", 24)
+	C.AppEnv = getenv("APP_ENV", "production")
+	C.JWTAlg = getenv("JWT_ALG", "EdDSA") // EdDSA | RS256 | HS256
+	C.JWTIssuer = getenv("JWT_ISSUER", "valpago")
+	C.JWTKeyRotationHours = getenvInt("JWT_KEY_ROTATION_HOURS", 720)
+	C.JWTKeyGraceHours = getenvInt("JWT_KEY_GRACE_HOURS", 24)
+	C.JWTKeyEncryptionSecret = getenv("JWT_KEY_ENCRYPTION_SECRET", "")
+	C.JWTSecret = os.Getenv("JWT_SECRET")
+	if C.IsDevelopment() && C.JWTSecret == "" {
+		C.JWTSecret = DevJWTSecret
+	}
+	switch C.JWTAlg {
+	case "EdDSA", "RS256":
+		if !C.IsDevelopment() && C.JWTKeyEncryptionSecret == "" {
+			return errors.New("JWT_KEY_ENCRYPTION_SECRET is required outside development")
+		}
+	case "HS256":
+		if !C.IsDevelopment() && (C.JWTSecret == "" || C.JWTSecret == DevJWTSecret) {
+			return errors.New("JWT_SECRET must be set to a non-default value outside development")
+		}
+	default:
+		return fmt.Errorf("unsupported JWT_ALG %q", C.JWTAlg)
+	}
+	C.JWTAccessTTLMinutes = getenvInt("JWT_ACCESS_TTL_MINUTES", 15)
+	C.RefreshTokenTTLHours = getenvInt("REFRESH_TOKEN_TTL_HOURS", 720)
+	C.LoginMaxAttempts = getenvInt("LOGIN_MAX_ATTEMPTS", 5)
+	C.LoginMaxAttemptsIP = getenvInt("LOGIN_MAX_ATTEMPTS_IP", 20)
+	C.LoginLockoutBaseSeconds = getenvInt("LOGIN_LOCKOUT_BASE_SECONDS", 60)
+	C.LoginLockoutMaxSeconds = getenvInt("LOGIN_LOCKOUT_MAX_SECONDS", 3600)
 	C.APIKeyHeader = getenv("API_KEY_HEADER_NAME", "x-api-key")
+	C.IdempotencyTTLHours = getenvInt("IDEMPOTENCY_TTL_HOURS", 24)
+	C.IdempotencyLockSeconds = getenvInt("IDEMPOTENCY_LOCK_SECONDS", 120) // un in_progress más viejo se da por abandonado (caída a mitad del request)
 	C.ServerPort = getenvInt("SERVER_PORT", 8080)
 	C.AllowedOrigins = getenv("ALLOWED_ORIGINS", "*")
+	// Graceful shutdown
+	C.ShutdownTimeoutSeconds = getenvInt("SHUTDOWN_TIMEOUT_SECONDS", 25) // por debajo del terminationGracePeriod (30s) de Kubernetes
+	C.SSERetryMillis = getenvInt("SSE_RETRY_MILLIS", 3000)               // retry que se envía a los clientes SSE al cerrar
+	// Passwords
+	C.PasswordMinLength = getenvInt("PASSWORD_MIN_LENGTH", 8)
+	C.PasswordResetTTLMinutes = getenvInt("PASSWORD_RESET_TTL_MINUTES", 10)
+	// Duplicate receipts
+	C.DuplicateWindowHours = getenvInt("DUPLICATE_WINDOW_HOURS", 2160) // 0 = sin límite
+	C.DuplicateDateToleranceHours = getenvInt("DUPLICATE_DATE_TOLERANCE_HOURS", 24)
+	C.ReceiptHashMaxDistance = getenvInt("RECEIPT_HASH_MAX_DISTANCE", 6) // bits distintos de 64
+	if C.ReceiptHashMaxDistance < 0 || C.ReceiptHashMaxDistance > 15 {
+		// Se indexa en distancia+1 bandas; con más, cada banda es tan corta que casi todo es candidato
+		return errors.New("RECEIPT_HASH_MAX_DISTANCE must be between 0 and 15")
+	}
+	// Notifications
+	C.NotifyDefaultLocale = getenv("NOTIFY_DEFAULT_LOCALE", "es")
+	C.WhatsappWebhookURL = getenv("WHATSAPP_WEBHOOK_URL", "")        // webhook genérico (p. ej. n8n) y respaldo de WhatsApp
+	C.WhatsappPhoneNumberID = getenv("WHATSAPP_PHONE_NUMBER_ID", "") // activa WhatsApp Cloud API con META_ACCESS_TOKEN
+	C.TelegramBotToken = getenv("TELEGRAM_BOT_TOKEN", "")
+	C.TelegramAPIURL = getenv("TELEGRAM_API_URL", "https://api.telegram.org")
+	C.SMTPHost = getenv("SMTP_HOST", "")
+	C.SMTPPort = getenvInt("SMTP_PORT", 587)
+	C.SMTPUser = getenv("SMTP_USER", "")
+	C.SMTPPassword = getenv("SMTP_PASSWORD", "")
+	C.SMTPFrom = getenv("SMTP_FROM", "")
+	// Notification outbox (dispatcher)
+	C.NotifyMaxAttempts = getenvInt("NOTIFY_MAX_ATTEMPTS", 8) // al agotarlos el aviso queda en dead
+	C.NotifyBackoffSeconds = getenvInt("NOTIFY_BACKOFF_SECONDS", 30)
+	C.NotifyBackoffMaxSeconds = getenvInt("NOTIFY_BACKOFF_MAX_SECONDS", 3600)
+	C.NotifyPollSeconds = getenvInt("NOTIFY_POLL_SECONDS", 5)
+	// Merchant webhooks (signed)
+	C.WebhookTimeoutSeconds = getenvInt("WEBHOOK_TIMEOUT_SECONDS", 10)
+	C.WebhookSecretGraceHours = getenvInt("WEBHOOK_SECRET_GRACE_HOURS", 24) // el secreto anterior sigue firmando tras rotar
+	// WhatsApp Cloud API inbound webhook
+	C.WhatsappVerifyToken = getenv("WHATSAPP_VERIFY_TOKEN", "")
+	C.WhatsappAppSecret = getenv("WHATSAPP_APP_SECRET", "")
+	C.WhatsappDefaultUserID = getenv("WHATSAPP_DEFAULT_USER_ID", "") // dueño de los drafts creados por el webhook
+	// Receipt storage
+	C.StorageBackend = getenv("STORAGE_BACKEND", "local") // local | s3 | supabase
+	C.StorageLocalDir = getenv("STORAGE_LOCAL_DIR", "./data/storage")
+	C.StorageLocalShared = getenv("STORAGE_LOCAL_SHARED", "false") == "true" // STORAGE_LOCAL_DIR es un volumen compartido entre API y workers
+	C.StorageSigningSecret = os.Getenv("STORAGE_SIGNING_SECRET")
+	if C.StorageBackend == "local" && C.StorageSigningSecret == "" {
+		if !C.IsDevelopment() {
+			return errors.New("STORAGE_SIGNING_SECRET is required for local storage outside development")
+		}
+		C.StorageSigningSecret = C.JWTSecret
+	}
+	C.StorageURLTTLMinutes = getenvInt("STORAGE_URL_TTL_MINUTES", 15)
+	C.PublicBaseURL = getenv("PUBLIC_BASE_URL", fmt.Sprintf("http://localhost:%d", C.ServerPort))
+	C.S3Endpoint = getenv("S3_ENDPOINT", "")
+	C.S3Region = getenv("S3_REGION", "us-east-1")
+	C.S3Bucket = getenv("S3_BUCKET", "")
+	C.S3AccessKey = getenv("S3_ACCESS_KEY", "")
+	C.S3SecretKey = getenv("S3_SECRET_KEY", "")
+	C.S3PathStyle = getenv("S3_PATH_STYLE", "true") == "true" // MinIO necesita path-style
+	// Receipt fetching (worker)
+	C.ReceiptFetchMaxAttempts = getenvInt("RECEIPT_FETCH_MAX_ATTEMPTS", 5)
+	C.ReceiptFetchBackoffSeconds = getenvInt("RECEIPT_FETCH_BACKOFF_SECONDS", 2) // se duplica en cada reintento
+	C.ReceiptFetchMaxSeconds = getenvInt("RECEIPT_FETCH_MAX_SECONDS", 60)        // tope de la descarga con reintentos; menor que WORKER_CLAIM_MIN_IDLE_SECONDS
+	// Worker stream recovery (XAUTOCLAIM + DLQ)
+	C.WorkerMaxDeliveries = getenvInt("WORKER_MAX_DELIVERIES", 5) // al superarlas la entrada pasa a <stream>:dlq
+	C.WorkerClaimIntervalSeconds = getenvInt("WORKER_CLAIM_INTERVAL_SECONDS", 30)
+	C.WorkerClaimMinIdleSeconds = getenvInt("WORKER_CLAIM_MIN_IDLE_SECONDS", 300) // mayor que el procesamiento más largo (descarga con reintentos)
+	C.WorkerConsumerIdleMinutes = getenvInt("WORKER_CONSUMER_IDLE_MINUTES", 60)
+	// Worker pool
+	C.WorkerConcurrency = getenvInt("WORKER_CONCURRENCY", 4) // los eventos de una misma transacción se procesan en orden
+	C.WorkerQueueSize = getenvInt("WORKER_QUEUE_SIZE", 16)   // por goroutine; al llenarse se deja de leer el stream
+	// Worker process
+	C.EmbeddedWorker = getenv("EMBEDDED_WORKER", "true") == "true" // false si el worker corre aparte (cmd/worker)
+	C.PodName = getenv("POD_NAME", "")                             // nombre estable del consumidor; si falta se usa el hostname
+	// Stream retention
+	C.NotificationsStreamMaxLen = getenvInt("NOTIFICATIONS_STREAM_MAXLEN", 10000) // tope aproximado del stream SSE (0 = sin tope)
+	C.DLQMaxLen = getenvInt("DLQ_MAXLEN", 100000)                                 // tope aproximado de la DLQ (0 = sin tope)
+	C.StreamRetentionHours = getenvInt("STREAM_RETENTION_HOURS", 168)             // las entradas confirmadas más antiguas se archivan en Mongo y se recortan
+	C.StreamArchiveIntervalSeconds = getenvInt("STREAM_ARCHIVE_INTERVAL_SECONDS", 300)
 	// External services
-	C.BearerTokenMeta = getenv("BEARER_TOKEN_FACE", "")
+	C.MetaGraphBaseURL = getenv("META_GRAPH_BASE_URL", "https://graph.facebook.com")
+	C.MetaGraphVersion = getenv("META_GRAPH_VERSION", "v18.0")
+	C.MetaTimeoutSeconds = getenvInt("META_TIMEOUT_SECONDS", 15)
+	C.BearerTokenMeta = getenv("META_ACCESS_TOKEN", getenv("BEARER_TOKEN_FACE", ""))
 	C.SupabaseProject = getenv("SUPABASE_PROJECT", "")
 	C.SupabaseBucket = getenv("SUPABASE_BUCKET", "")
 	C.SupabaseURLProject = getenv("SUPABASE_URL_PROJECT", "")
@@ -34,3 +34,11 @@ func ConnectRedis(url string) error {
 	fmt.Println("Redis connected successfully")
 	return nil
 }
+
+// CloseRedis cierra el cliente al apagar el servidor
+func CloseRedis() error {
+	if Rdb == nil {
+		return nil
+	}
+	return Rdb.Close()
+}
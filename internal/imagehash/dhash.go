@@ -0,0 +1,144 @@
+// Package imagehash calcula hashes perceptuales (dHash) de imágenes en Go puro,
+// para reconocer comprobantes reutilizados aunque hayan sido recomprimidos o redimensionados.
+package imagehash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"strconv"
+)
+
+// dHash compara cada pixel con su vecino derecho en una imagen reducida a (hashWidth+1) x hashHeight
+const (
+	hashWidth  = 8
+	hashHeight = 8
+	// maxPixels limita el tamaño declarado de la imagen antes de decodificarla: unos pocos bytes de
+	// PNG pueden declarar dimensiones que ocupan gigabytes al descomprimirse
+	maxPixels = 40_000_000
+)
+
+var ErrImageTooLarge = errors.New("image dimensions too large")
+
+// Hash es un dHash de 64 bits
+type Hash uint64
+
+// DHash calcula el hash por diferencias de la imagen
+func DHash(img image.Image) Hash {
+	gray := downscale(img, hashWidth+1, hashHeight)
+	var h Hash
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth; x++ {
+			h <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// FromBytes decodifica la imagen (JPEG, PNG o GIF) y calcula su dHash
+func FromBytes(data []byte) (Hash, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("decoding image: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return 0, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("decoding image: %w", err)
+	}
+	return DHash(img), nil
+}
+
+// Distance es la distancia de Hamming entre dos hashes (0 = idénticos, 64 = opuestos)
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// String devuelve el hash como 16 caracteres hexadecimales, que es como se guarda en MongoDB
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Bands parte el hash en n bandas contiguas (de 64/n bits, redondeado) como "<n>.<i>:<hex>" para
+// indexarlo. Por el principio del palomar, dos hashes a distancia < n comparten al menos una banda:
+// para encontrar todo lo que está a distancia <= d hay que usar n = d+1.
+func (h Hash) Bands(n int) []string {
+	if n < 1 {
+		n = 1
+	}
+	if n > 64 {
+		n = 64
+	}
+	bands := make([]string, n)
+	start := 0
+	for i := range bands {
+		end := 64 * (i + 1) / n
+		width := end - start
+		v := (uint64(h) >> (64 - end)) & (1<<width - 1)
+		bands[i] = fmt.Sprintf("%d.%d:%x", n, i, v)
+		start = end
+	}
+	return bands
+}
+
+// Parse interpreta un hash producido por String
+func Parse(s string) (Hash, error) {
+	if len(s) != 16 {
+		return 0, errors.New("invalid hash length")
+	}
+	n, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return Hash(n), nil
+}
+
+// downscale reduce la imagen a w x h en escala de grises promediando el área que cubre cada celda
+func downscale(img image.Image, w, h int) [][]float64 {
+	b := img.Bounds()
+	out := make([][]float64, h)
+	for cy := 0; cy < h; cy++ {
+		out[cy] = make([]float64, w)
+		y0 := b.Min.Y + cy*b.Dy()/h
+		y1 := b.Min.Y + (cy+1)*b.Dy()/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for cx := 0; cx < w; cx++ {
+			x0 := b.Min.X + cx*b.Dx()/w
+			x1 := b.Min.X + (cx+1)*b.Dx()/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum float64
+			var n int
+			for y := y0; y < y1 && y < b.Max.Y; y++ {
+				for x := x0; x < x1 && x < b.Max.X; x++ {
+					sum += luminance(img, x, y)
+					n++
+				}
+			}
+			if n > 0 {
+				out[cy][cx] = sum / float64(n)
+			}
+		}
+	}
+	return out
+}
+
+// luminance usa los pesos ITU-R BT.601 sobre los valores de 16 bits de color.RGBA
+func luminance(img image.Image, x, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
@@ -0,0 +1,99 @@
+package imagehash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	cases := []struct {
+		a, b Hash
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xffffffffffffffff, 0, 64},
+		{0xf0f0f0f0f0f0f0f0, 0x0f0f0f0f0f0f0f0f, 64},
+		{0x0123456789abcdef, 0x0123456789abcdee, 1},
+	}
+	for _, c := range cases {
+		if got := Distance(c.a, c.b); got != c.want {
+			t.Errorf("Distance(%x, %x) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestBandsLayout(t *testing.T) {
+	got := Hash(0x0123456789abcdef).Bands(4)
+	want := []string{"4.0:123", "4.1:4567", "4.2:89ab", "4.3:cdef"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Bands(4) = %v, want %v", got, want)
+		}
+	}
+	for _, n := range []int{1, 3, 7, 16, 64} {
+		if got := len(Hash(0).Bands(n)); got != n {
+			t.Errorf("len(Bands(%d)) = %d", n, got)
+		}
+	}
+}
+
+// Con n = d+1 bandas, dos hashes a distancia <= d siempre comparten una banda
+func TestBandsShareWithinDistance(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for d := 0; d <= 15; d++ {
+		for i := 0; i < 500; i++ {
+			a := Hash(r.Uint64())
+			b := a
+			for bits.OnesCount64(uint64(a^b)) < d {
+				b ^= 1 << r.Intn(64)
+			}
+			if !shareBand(a.Bands(d+1), b.Bands(d+1)) {
+				t.Fatalf("%x and %x at distance %d share no band of %d", a, b, Distance(a, b), d+1)
+			}
+		}
+	}
+}
+
+func TestBandsDifferWhenAllDiffer(t *testing.T) {
+	// Un bit distinto en cada una de las 4 bandas: distancia 4, ninguna banda en común
+	a := Hash(0)
+	b := Hash(1 | 1<<16 | 1<<32 | 1<<48)
+	if shareBand(a.Bands(4), b.Bands(4)) {
+		t.Fatalf("hashes differing in every band should not share one")
+	}
+	if !shareBand(a.Bands(5), b.Bands(5)) {
+		t.Fatalf("distance 4 must share a band with 5 bands")
+	}
+}
+
+func TestFromBytesRejectsHugeDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// IHDR: ancho y alto en los bytes 16-23 y su CRC en 29-32; se declara 100000 x 100000 sin cambiar el contenido
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, err := FromBytes(data); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("FromBytes = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func shareBand(a, b []string) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			return true
+		}
+	}
+	return false
+}
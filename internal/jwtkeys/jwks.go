@@ -0,0 +1,106 @@
+package jwtkeys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/usuario/valpago-backend/internal/config"
+)
+
+// JWK es una llave pública en formato RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS devuelve las llaves públicas vigentes (incluida la próxima a activarse).
+// Con HS256 el conjunto está vacío: un secreto compartido no se publica.
+func JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, k := range keys {
+		if now.After(k.VerifyUntil) {
+			continue
+		}
+		switch pub := k.public.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP", Crv: "Ed25519", Kid: k.ID, Alg: k.Alg, Use: "sig",
+				X: base64.RawURLEncoding.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA", Kid: k.ID, Alg: k.Alg, Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	return set
+}
+
+// encrypt cifra la llave privada con AES-256-GCM derivando la llave de JWT_KEY_ENCRYPTION_SECRET
+func encrypt(plain string) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decrypt(encoded string) (string, error) {
+	if config.C.JWTKeyEncryptionSecret == "" {
+		return "", errors.New("key is encrypted but JWT_KEY_ENCRYPTION_SECRET is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newGCM() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(config.C.JWTKeyEncryptionSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
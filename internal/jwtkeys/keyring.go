@@ -0,0 +1,372 @@
+// Package jwtkeys administra las llaves con las que se firman los JWT de ValPago:
+// generación, rotación programada (compartida entre instancias vía MongoDB),
+// verificación contra cualquier llave vigente y publicación como JWKS.
+package jwtkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+const (
+	collection = "signing_keys"
+	hmacKeyID  = "hs256"
+	rsaBits    = 2048
+	// refreshInterval es cada cuánto se recargan las llaves y se revisa si toca rotar
+	refreshInterval = 5 * time.Minute
+	// missReloadInterval limita las recargas por un kid desconocido (llave recién creada por otra instancia)
+	missReloadInterval = 10 * time.Second
+)
+
+// Key es una llave de firma. Firma entre ActivatesAt y SignUntil, y se sigue
+// aceptando (y publicando en el JWKS) hasta VerifyUntil.
+type Key struct {
+	ID          string
+	Alg         string
+	ActivatesAt time.Time
+	SignUntil   time.Time
+	VerifyUntil time.Time
+
+	private interface{} // ed25519.PrivateKey | *rsa.PrivateKey | []byte (HS256)
+	public  interface{} // ed25519.PublicKey | *rsa.PublicKey | []byte (HS256)
+}
+
+// storedKey es la representación en MongoDB; la llave privada va cifrada con JWT_KEY_ENCRYPTION_SECRET si está definido
+type storedKey struct {
+	ID          string    `bson:"_id"`
+	Alg         string    `bson:"alg"`
+	PrivateKey  string    `bson:"privateKey"`
+	Encrypted   bool      `bson:"encrypted"`
+	CreatedAt   time.Time `bson:"createdAt"`
+	ActivatesAt time.Time `bson:"activatesAt"`
+	SignUntil   time.Time `bson:"signUntil"`
+	VerifyUntil time.Time `bson:"verifyUntil"`
+	// After es el ID de la llave a la que sucede ("" la primera). Tiene índice único: si varias
+	// instancias generan la siguiente llave a la vez, solo una la inserta y las demás la recargan.
+	After string `bson:"after"`
+}
+
+var (
+	mu   sync.RWMutex
+	keys []*Key // ordenadas de la más nueva a la más vieja
+
+	reloadMu   sync.Mutex
+	lastReload time.Time
+)
+
+// Init carga (o genera) las llaves según JWT_ALG. Con HS256 se usa JWT_SECRET y no se publica JWKS.
+func Init(ctx context.Context) error {
+	if config.C.JWTAlg == "HS256" {
+		secret := []byte(config.C.JWTSecret)
+		mu.Lock()
+		keys = []*Key{{ID: hmacKeyID, Alg: "HS256", private: secret, public: secret}}
+		mu.Unlock()
+		return nil
+	}
+	return refresh(ctx)
+}
+
+// StartRotation recarga las llaves periódicamente y genera la siguiente cuando se acerca la rotación
+func StartRotation(ctx context.Context) {
+	if config.C.JWTAlg == "HS256" {
+		return
+	}
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := refresh(ctx); err != nil {
+				log.Printf("jwt key refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// Sign firma los claims con la llave activa, incluyendo su kid en el header
+func Sign(claims jwt.Claims) (string, error) {
+	key := signingKey(time.Now())
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Keyfunc resuelve la llave pública según el kid del token; sirve para jwt.ParseWithClaims.
+// Si el kid no se conoce recarga las llaves de MongoDB (a lo sumo cada missReloadInterval),
+// por si otra instancia acaba de generarla.
+func Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, err := lookupKey(kid, t.Method.Alg())
+	if !errors.Is(err, errUnknownKey) || config.C.JWTAlg == "HS256" || !reloadOnMiss() {
+		return key, err
+	}
+	return lookupKey(kid, t.Method.Alg())
+}
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// lookupKey busca el kid entre las llaves cargadas; devuelve (nil, error) si no está
+func lookupKey(kid, alg string) (interface{}, error) {
+	now := time.Now()
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, k := range keys {
+		if k.ID != kid {
+			continue
+		}
+		if k.Alg != alg {
+			return nil, fmt.Errorf("unexpected signing method %s for key %s", alg, kid)
+		}
+		if k.Alg != "HS256" && now.After(k.VerifyUntil) {
+			return nil, fmt.Errorf("signing key %s has been retired", kid)
+		}
+		return k.public, nil
+	}
+	return nil, fmt.Errorf("%w %q", errUnknownKey, kid)
+}
+
+// reloadOnMiss recarga las llaves si no se hizo hace poco; devuelve si hubo recarga
+func reloadOnMiss() bool {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if time.Since(lastReload) < missReloadInterval {
+		return false
+	}
+	lastReload = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	loaded, err := loadKeys(ctx)
+	if err != nil {
+		log.Printf("Failed to reload JWT signing keys: %v", err)
+		return false
+	}
+	mu.Lock()
+	keys = loaded
+	mu.Unlock()
+	return true
+}
+
+// ValidMethods devuelve el algoritmo configurado, para jwt.WithValidMethods
+func ValidMethods() []string {
+	return []string{config.C.JWTAlg}
+}
+
+func signingKey(now time.Time) *Key {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, k := range keys {
+		if k.Alg == "HS256" || (!now.Before(k.ActivatesAt) && now.Before(k.SignUntil)) {
+			return k
+		}
+	}
+	return nil
+}
+
+// refresh recarga las llaves vigentes desde MongoDB y, si la más nueva está por dejar de firmar,
+// genera la siguiente con antelación (JWT_KEY_GRACE_HOURS) para que los consumidores del JWKS la vean antes de usarse
+func refresh(ctx context.Context) error {
+	loaded, err := loadKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	grace := time.Duration(config.C.JWTKeyGraceHours) * time.Hour
+	if len(loaded) == 0 || loaded[0].SignUntil.Sub(now) < grace {
+		activatesAt := now
+		after := ""
+		if len(loaded) > 0 {
+			after = loaded[0].ID
+			if loaded[0].SignUntil.After(now) {
+				activatesAt = loaded[0].SignUntil
+			}
+		} else if after, err = latestKeyID(ctx); err != nil {
+			return err
+		}
+
+		key, err := generateKey(ctx, config.C.JWTAlg, activatesAt, after)
+		switch {
+		case mongo.IsDuplicateKeyError(err):
+			// Otra instancia generó la misma sucesora primero: usar la suya
+			if loaded, err = loadKeys(ctx); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			log.Printf("Generated JWT signing key %s (%s), active from %s", key.ID, key.Alg, key.ActivatesAt.Format(time.RFC3339))
+			loaded = append([]*Key{key}, loaded...)
+		}
+	}
+
+	reloadMu.Lock()
+	lastReload = now
+	reloadMu.Unlock()
+	mu.Lock()
+	keys = loaded
+	mu.Unlock()
+	return nil
+}
+
+func loadKeys(ctx context.Context) ([]*Key, error) {
+	cursor, err := db.Mongo().Collection(collection).Find(ctx, bson.M{
+		"alg":         config.C.JWTAlg,
+		"verifyUntil": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stored []storedKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+
+	loaded := make([]*Key, 0, len(stored))
+	for _, s := range stored {
+		k, err := decodeKey(s)
+		if err != nil {
+			log.Printf("Skipping JWT signing key %s: %v", s.ID, err)
+			continue
+		}
+		loaded = append(loaded, k)
+	}
+	sort.Slice(loaded, func(i, j int) bool {
+		if loaded[i].ActivatesAt.Equal(loaded[j].ActivatesAt) {
+			return loaded[i].ID > loaded[j].ID
+		}
+		return loaded[i].ActivatesAt.After(loaded[j].ActivatesAt)
+	})
+	return loaded, nil
+}
+
+// latestKeyID es el ID de la llave más nueva del algoritmo aunque ya esté vencida ("" si no hay ninguna),
+// para encadenar la primera llave que se genere tras un periodo sin llaves vigentes
+func latestKeyID(ctx context.Context) (string, error) {
+	var latest storedKey
+	err := db.Mongo().Collection(collection).FindOne(ctx,
+		bson.M{"alg": config.C.JWTAlg},
+		options.FindOne().SetSort(bson.D{{Key: "activatesAt", Value: -1}}).SetProjection(bson.M{"_id": 1}),
+	).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return latest.ID, err
+}
+
+func generateKey(ctx context.Context, alg string, activatesAt time.Time, after string) (*Key, error) {
+	var private, public interface{}
+	switch alg {
+	case "EdDSA":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private, public = priv, pub
+	case "RS256":
+		priv, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			return nil, err
+		}
+		private, public = priv, &priv.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	encoded := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	encrypted := false
+	if config.C.JWTKeyEncryptionSecret != "" {
+		if encoded, err = encrypt(encoded); err != nil {
+			return nil, err
+		}
+		encrypted = true
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return nil, err
+	}
+	rotation := time.Duration(config.C.JWTKeyRotationHours) * time.Hour
+	grace := time.Duration(config.C.JWTKeyGraceHours) * time.Hour
+	key := &Key{
+		ID:          fmt.Sprintf("%s-%x", activatesAt.UTC().Format("20060102"), kidBytes),
+		Alg:         alg,
+		ActivatesAt: activatesAt,
+		SignUntil:   activatesAt.Add(rotation),
+		VerifyUntil: activatesAt.Add(rotation + grace),
+		private:     private,
+		public:      public,
+	}
+
+	_, err = db.Mongo().Collection(collection).InsertOne(ctx, storedKey{
+		ID:          key.ID,
+		Alg:         alg,
+		PrivateKey:  encoded,
+		Encrypted:   encrypted,
+		CreatedAt:   time.Now(),
+		ActivatesAt: key.ActivatesAt,
+		SignUntil:   key.SignUntil,
+		VerifyUntil: key.VerifyUntil,
+		After:       after,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func decodeKey(s storedKey) (*Key, error) {
+	encoded := s.PrivateKey
+	if s.Encrypted {
+		var err error
+		if encoded, err = decrypt(encoded); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Key{ID: s.ID, Alg: s.Alg, ActivatesAt: s.ActivatesAt, SignUntil: s.SignUntil, VerifyUntil: s.VerifyUntil}
+	switch priv := parsed.(type) {
+	case ed25519.PrivateKey:
+		k.private, k.public = priv, priv.Public()
+	case *rsa.PrivateKey:
+		k.private, k.public = priv, &priv.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return k, nil
+}
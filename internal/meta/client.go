@@ -0,0 +1,208 @@
+// Package meta es el cliente de Graph API de Meta (WhatsApp Cloud API) que usa ValPago
+// para resolver y descargar media. La URL base, la versión y el token son configurables,
+// lo que permite apuntarlo a un servidor falso (ver metafake) para trabajar sin conexión.
+package meta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usuario/valpago-backend/internal/config"
+)
+
+const (
+	DefaultBaseURL = "https://graph.facebook.com"
+	DefaultVersion = "v18.0"
+	DefaultTimeout = 15 * time.Second
+	// MaxMediaBytes limita el tamaño de un media descargado
+	MaxMediaBytes = 10 << 20
+)
+
+// TokenSource entrega el access token a usar en cada llamada
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// Refresher es opcional: si el TokenSource lo implementa, ante un token inválido el cliente
+// llama a Refresh y reintenta la llamada una vez con el token nuevo
+type Refresher interface {
+	Refresh(ctx context.Context) (string, error)
+}
+
+// StaticToken es un token fijo (p. ej. el de un system user)
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) { return string(t), nil }
+
+// TokenFunc adapta una función a TokenSource
+type TokenFunc func(ctx context.Context) (string, error)
+
+func (f TokenFunc) Token(ctx context.Context) (string, error) { return f(ctx) }
+
+// Client llama a Graph API
+type Client struct {
+	BaseURL string
+	Version string
+	Tokens  TokenSource
+	HTTP    *http.Client
+}
+
+// Media es la metadata de un media de WhatsApp (GET /<version>/<media-id>)
+type Media struct {
+	ID               string `json:"id"`
+	URL              string `json:"url"`
+	MimeType         string `json:"mime_type"`
+	SHA256           string `json:"sha256"`
+	FileSize         int64  `json:"file_size"`
+	MessagingProduct string `json:"messaging_product"`
+}
+
+// New crea un cliente; los campos vacíos toman los valores por defecto
+func New(baseURL, version string, timeout time.Duration, tokens TokenSource) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if version == "" {
+		version = DefaultVersion
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if tokens == nil {
+		tokens = StaticToken("")
+	}
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Version: strings.Trim(version, "/"),
+		Tokens:  tokens,
+		HTTP:    &http.Client{Timeout: timeout},
+	}
+}
+
+// FromConfig crea el cliente con META_GRAPH_BASE_URL, META_GRAPH_VERSION, META_TIMEOUT_SECONDS y el token de Meta
+func FromConfig() *Client {
+	return New(config.C.MetaGraphBaseURL, config.C.MetaGraphVersion,
+		time.Duration(config.C.MetaTimeoutSeconds)*time.Second, StaticToken(config.C.BearerTokenMeta))
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultClient *Client
+)
+
+// Default devuelve el cliente de la aplicación (creado desde la configuración la primera vez)
+func Default() *Client {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultClient == nil {
+		defaultClient = FromConfig()
+	}
+	return defaultClient
+}
+
+// SetDefault reemplaza el cliente de la aplicación (p. ej. con un TokenSource que refresca el token)
+func SetDefault(c *Client) {
+	defaultMu.Lock()
+	defaultClient = c
+	defaultMu.Unlock()
+}
+
+// GetMedia resuelve un media ID a su URL de descarga (válida por pocos minutos)
+func (c *Client) GetMedia(ctx context.Context, mediaID string) (*Media, error) {
+	if mediaID == "" || strings.ContainsAny(mediaID, "/?#") {
+		return nil, fmt.Errorf("meta: invalid media id %q", mediaID)
+	}
+	resp, err := c.do(ctx, fmt.Sprintf("%s/%s/%s", c.BaseURL, c.Version, mediaID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var media Media
+	if err := json.NewDecoder(resp.Body).Decode(&media); err != nil {
+		return nil, err
+	}
+	if media.URL == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: "empty media url"}
+	}
+	return &media, nil
+}
+
+// Download descarga el contenido de una URL de media (requiere el mismo Bearer token)
+func (c *Client) Download(ctx context.Context, url string) ([]byte, string, error) {
+	resp, err := c.do(ctx, url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxMediaBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > MaxMediaBytes {
+		return nil, "", fmt.Errorf("meta: media exceeds %d bytes", MaxMediaBytes)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
+
+// FetchMedia combina GetMedia y Download
+func (c *Client) FetchMedia(ctx context.Context, mediaID string) ([]byte, string, error) {
+	media, err := c.GetMedia(ctx, mediaID)
+	if err != nil {
+		return nil, "", err
+	}
+	return c.Download(ctx, media.URL)
+}
+
+// do hace un GET autenticado; devuelve *Error para respuestas no 2xx y, si el token es inválido
+// y el TokenSource sabe refrescarse, reintenta una vez con el token nuevo
+func (c *Client) do(ctx context.Context, url string) (*http.Response, error) {
+	token, err := c.Tokens.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("meta: getting token: %w", err)
+	}
+	resp, err := c.get(ctx, url, token)
+	if err == nil {
+		return resp, nil
+	}
+	refresher, ok := c.Tokens.(Refresher)
+	if !ok || !errors.Is(err, ErrInvalidToken) {
+		return nil, err
+	}
+	if token, err = refresher.Refresh(ctx); err != nil {
+		return nil, fmt.Errorf("meta: refreshing token: %w", err)
+	}
+	return c.get(ctx, url, token)
+}
+
+func (c *Client) get(ctx context.Context, url, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, parseError(resp, body)
+	}
+	return resp, nil
+}
@@ -0,0 +1,103 @@
+package meta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrMediaExpired: el media ID ya no existe en Meta o la URL de descarga caducó
+	ErrMediaExpired = errors.New("meta: media expired or not found")
+	// ErrInvalidToken: el access token es inválido o expiró (OAuthException 190)
+	ErrInvalidToken = errors.New("meta: invalid access token")
+	// ErrRateLimited: Meta limitó las llamadas; ver Error.RetryAfter
+	ErrRateLimited = errors.New("meta: rate limited")
+)
+
+// Códigos de error de Graph API relevantes
+const (
+	codeInvalidParameter = 100
+	codeInvalidToken     = 190
+)
+
+// rateLimitCodes son los códigos de throttling de Graph API y de WhatsApp Cloud API
+var rateLimitCodes = map[int]bool{4: true, 17: true, 32: true, 613: true, 80007: true, 130429: true, 131056: true}
+
+// Error es un error devuelto por Graph API. Se compara con errors.Is contra
+// ErrMediaExpired, ErrInvalidToken y ErrRateLimited.
+type Error struct {
+	Status     int
+	Code       int
+	Subcode    int
+	Type       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("meta: status %d, code %d: %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("meta: status %d", e.Status)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrMediaExpired:
+		return e.Status == http.StatusNotFound || e.Status == http.StatusGone ||
+			(e.Status == http.StatusBadRequest && e.Code == codeInvalidParameter)
+	case ErrInvalidToken:
+		return e.Code == codeInvalidToken || (e.Code == 0 && e.Status == http.StatusUnauthorized)
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests || rateLimitCodes[e.Code]
+	}
+	return false
+}
+
+// IsRetryable indica si vale la pena reintentar: throttling, errores 5xx, fallas de red y timeouts.
+// Media expirado, token inválido, demás 4xx y cualquier otro error (JSON inválido, tamaño
+// excedido...) son definitivos.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return errors.Is(apiErr, ErrRateLimited) || apiErr.Status >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// parseError arma el Error a partir de una respuesta no 2xx ({"error": {...}} de Graph API)
+func parseError(resp *http.Response, body []byte) *Error {
+	e := &Error{Status: resp.StatusCode}
+	var payload struct {
+		Error struct {
+			Message      string `json:"message"`
+			Type         string `json:"type"`
+			Code         int    `json:"code"`
+			ErrorSubcode int    `json:"error_subcode"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		e.Code = payload.Error.Code
+		e.Subcode = payload.Error.ErrorSubcode
+		e.Type = payload.Error.Type
+		e.Message = payload.Error.Message
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
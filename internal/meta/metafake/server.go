@@ -0,0 +1,163 @@
+// Package metafake es un servidor falso de Graph API para el flujo de media de WhatsApp:
+// GET /<version>/<media-id> devuelve la metadata y GET /media/<media-id> el contenido.
+// Permite simular media expirado, token inválido y rate limiting sin conexión a Meta.
+package metafake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+type media struct {
+	data        []byte
+	contentType string
+	expired     bool
+}
+
+// Server guarda los media en memoria. Sirve como http.Handler (para montarlo en cualquier
+// servidor) o levantado con Start sobre httptest.
+type Server struct {
+	// Token es el Bearer esperado; vacío acepta cualquiera
+	Token   string
+	Version string
+
+	mu          sync.Mutex
+	media       map[string]*media
+	rateLimited int
+	baseURL     string
+	ts          *httptest.Server
+}
+
+// New crea un servidor vacío que espera el token indicado
+func New(token string) *Server {
+	return &Server{Token: token, Version: "v18.0", media: map[string]*media{}}
+}
+
+// Start levanta el servidor en un puerto local y devuelve su URL base
+func (s *Server) Start() string {
+	s.ts = httptest.NewServer(s)
+	s.SetBaseURL(s.ts.URL)
+	return s.ts.URL
+}
+
+// Close detiene el servidor levantado con Start
+func (s *Server) Close() {
+	if s.ts != nil {
+		s.ts.Close()
+	}
+}
+
+// SetBaseURL fija la URL pública con la que se arman las URLs de descarga
+func (s *Server) SetBaseURL(url string) {
+	s.mu.Lock()
+	s.baseURL = strings.TrimRight(url, "/")
+	s.mu.Unlock()
+}
+
+// AddMedia registra un media descargable
+func (s *Server) AddMedia(id string, data []byte, contentType string) {
+	s.mu.Lock()
+	s.media[id] = &media{data: data, contentType: contentType}
+	s.mu.Unlock()
+}
+
+// ExpireMedia hace que el media responda como vencido (404 de Graph API)
+func (s *Server) ExpireMedia(id string) {
+	s.mu.Lock()
+	if m, ok := s.media[id]; ok {
+		m.expired = true
+	}
+	s.mu.Unlock()
+}
+
+// RateLimitNext hace que las próximas n llamadas respondan 429 (código 4)
+func (s *Server) RateLimitNext(n int) {
+	s.mu.Lock()
+	s.rateLimited = n
+	s.mu.Unlock()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, 100, "Unsupported method")
+		return
+	}
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, 190, "Invalid OAuth access token")
+		return
+	}
+
+	s.mu.Lock()
+	if s.rateLimited > 0 {
+		s.rateLimited--
+		s.mu.Unlock()
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, 4, "Application request limit reached")
+		return
+	}
+	baseURL := s.baseURL
+	if baseURL == "" {
+		baseURL = "http://" + r.Host
+	}
+	s.mu.Unlock()
+
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case strings.HasPrefix(path, "media/"):
+		m := s.lookup(strings.TrimPrefix(path, "media/"))
+		if m == nil {
+			writeError(w, http.StatusNotFound, 100, "Media not found")
+			return
+		}
+		w.Header().Set("Content-Type", m.contentType)
+		_, _ = w.Write(m.data)
+	case strings.HasPrefix(path, s.Version+"/"):
+		id := strings.TrimPrefix(path, s.Version+"/")
+		m := s.lookup(id)
+		if m == nil {
+			writeError(w, http.StatusBadRequest, 100, fmt.Sprintf("Unsupported get request. Object with ID '%s' does not exist", id))
+			return
+		}
+		sum := sha256.Sum256(m.data)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"messaging_product": "whatsapp",
+			"id":                id,
+			"url":               baseURL + "/media/" + id,
+			"mime_type":         m.contentType,
+			"sha256":            hex.EncodeToString(sum[:]),
+			"file_size":         len(m.data),
+		})
+	default:
+		writeError(w, http.StatusNotFound, 100, "Unknown path")
+	}
+}
+
+// lookup devuelve el media vigente o nil si no existe o expiró
+func (s *Server) lookup(id string) *media {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.media[id]
+	if !ok || m.expired {
+		return nil
+	}
+	return m
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    "OAuthException",
+			"code":    code,
+		},
+	})
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/usuario/valpago-backend/internal/config"
+)
+
+const (
+	ChannelWhatsapp = "whatsapp"
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelTelegram = "telegram"
+)
+
+// Channels son los canales soportados, en el orden en que se documentan
+var Channels = []string{ChannelWhatsapp, ChannelWebhook, ChannelEmail, ChannelTelegram}
+
+// Message es el contenido a entregar; Subject solo aplica a canales que lo soportan (email)
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// Response es lo que respondió el proveedor; en canales sin HTTP (SMTP) queda vacía
+type Response struct {
+	StatusCode int
+	Body       string
+}
+
+// Notifier entrega un mensaje a un destinatario (teléfono, email, chat de Telegram, ...) por un canal concreto.
+// Devuelve la respuesta del proveedor también cuando hay error, para dejarla registrada.
+type Notifier interface {
+	Send(ctx context.Context, to string, msg Message) (*Response, error)
+}
+
+// Destination es un canal configurado por un comercio (ver Merchant.Notifications)
+type Destination struct {
+	Channel string `json:"channel" bson:"channel"`
+	// To es el teléfono, email o chat ID según el canal; en webhook es opcional (se envía como "tel")
+	To string `json:"to,omitempty" bson:"to,omitempty"`
+	// URL solo aplica a webhook; vacía usa WHATSAPP_WEBHOOK_URL
+	URL      string `json:"url,omitempty" bson:"url,omitempty"`
+	Locale   string `json:"locale,omitempty" bson:"locale,omitempty"`
+	Disabled bool   `json:"disabled,omitempty" bson:"disabled,omitempty"`
+}
+
+// Validate revisa que el destino tenga lo necesario para su canal
+func (d Destination) Validate() error {
+	switch d.Channel {
+	case ChannelWhatsapp, ChannelEmail, ChannelTelegram:
+		if d.To == "" {
+			return fmt.Errorf("%s destination requires to", d.Channel)
+		}
+	case ChannelWebhook:
+		if d.URL != "" {
+			u, err := url.Parse(d.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid webhook url: %s", d.URL)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown notification channel: %s", d.Channel)
+	}
+	if d.Locale != "" && !SupportedLocale(d.Locale) {
+		return fmt.Errorf("unsupported locale: %s", d.Locale)
+	}
+	return nil
+}
+
+// New construye el notifier del canal indicado a partir de la configuración.
+// WhatsApp usa Cloud API si hay WHATSAPP_PHONE_NUMBER_ID; si no, el webhook de WHATSAPP_WEBHOOK_URL.
+func New(channel string) (Notifier, error) {
+	switch channel {
+	case ChannelWhatsapp:
+		if config.C.WhatsappPhoneNumberID != "" {
+			return NewWhatsappCloud(config.C.MetaGraphBaseURL, config.C.MetaGraphVersion, config.C.WhatsappPhoneNumberID, config.C.BearerTokenMeta), nil
+		}
+		if config.C.WhatsappWebhookURL == "" {
+			return nil, fmt.Errorf("whatsapp not configured")
+		}
+		return NewWebhook(config.C.WhatsappWebhookURL), nil
+	case ChannelWebhook:
+		if config.C.WhatsappWebhookURL == "" {
+			return nil, fmt.Errorf("webhook not configured")
+		}
+		return NewWebhook(config.C.WhatsappWebhookURL), nil
+	case ChannelEmail:
+		if config.C.SMTPHost == "" || config.C.SMTPFrom == "" {
+			return nil, fmt.Errorf("smtp not configured")
+		}
+		return NewSMTP(config.C.SMTPHost, config.C.SMTPPort, config.C.SMTPUser, config.C.SMTPPassword, config.C.SMTPFrom), nil
+	case ChannelTelegram:
+		if config.C.TelegramBotToken == "" {
+			return nil, fmt.Errorf("telegram not configured")
+		}
+		return NewTelegram(config.C.TelegramAPIURL, config.C.TelegramBotToken), nil
+	default:
+		return nil, fmt.Errorf("unknown notification channel: %s", channel)
+	}
+}
+
+// ForDestination construye el notifier de un destino de comercio; un webhook con URL propia
+// no depende de la configuración global
+func ForDestination(d Destination) (Notifier, error) {
+	if d.Channel == ChannelWebhook && d.URL != "" {
+		return NewWebhook(d.URL), nil
+	}
+	return New(d.Channel)
+}
+
+// truncate limita el body de respuesta que se conserva
+func truncate(b []byte, n int) string {
+	if len(b) > n {
+		b = b[:n]
+	}
+	return strings.ToValidUTF8(string(b), "")
+}
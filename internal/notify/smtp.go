@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP envía mensajes como email de texto plano
+type SMTP struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+}
+
+func NewSMTP(host string, port int, user, password, from string) *SMTP {
+	return &SMTP{Host: host, Port: port, User: user, Password: password, From: from}
+}
+
+func (s *SMTP) Send(ctx context.Context, to string, msg Message) (*Response, error) {
+	var auth smtp.Auth
+	if s.User != "" {
+		auth = smtp.PlainAuth("", s.User, s.Password, s.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	// El asunto puede venir de una plantilla del comercio: sin saltos de línea no se pueden inyectar cabeceras
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.Body)
+
+	// net/smtp no acepta contexto; se respeta la cancelación previa al envío
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	return &Response{}, smtp.SendMail(addr, auth, s.From, []string{to}, []byte(b.String()))
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultTelegramAPIURL = "https://api.telegram.org"
+
+// Telegram envía mensajes con la Bot API (sendMessage); el destinatario es el chat ID
+type Telegram struct {
+	APIURL string
+	Token  string
+	Client *http.Client
+}
+
+type telegramMessage struct {
+	ChatID string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+func NewTelegram(apiURL, token string) *Telegram {
+	if apiURL == "" {
+		apiURL = DefaultTelegramAPIURL
+	}
+	return &Telegram{APIURL: strings.TrimRight(apiURL, "/"), Token: token, Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (t *Telegram) Send(ctx context.Context, to string, msg Message) (*Response, error) {
+	text := msg.Body
+	if msg.Subject != "" {
+		text = msg.Subject + "\n\n" + msg.Body
+	}
+	body, err := json.Marshal(telegramMessage{ChatID: to, Text: text})
+	if err != nil {
+		return nil, err
+	}
+	// El token va en la ruta: el error de postJSON solo incluye el host
+	return postJSON(ctx, t.Client, t.APIURL+"/bot"+t.Token+"/sendMessage", nil, body)
+}
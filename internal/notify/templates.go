@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/usuario/valpago-backend/internal/config"
+)
+
+const (
+	EventApproved = "transaction.approved"
+	EventRejected = "transaction.rejected"
+)
+
+// Template es el asunto y el cuerpo de un aviso. Admite los placeholders {amount}, {reference},
+// {merchant}, {transaction_id}, {date} y {reason}.
+type Template struct {
+	Subject string `json:"subject,omitempty" bson:"subject,omitempty"`
+	Body    string `json:"body" bson:"body"`
+}
+
+// templates son las plantillas por defecto por idioma y evento
+var templates = map[string]map[string]Template{
+	"es": {
+		EventApproved: {
+			Subject: "ValPago - Transacción aprobada",
+			Body:    "💲Transacción aprobada ✅✅✅🧾\nMonto: {amount}\nReferencia: {reference}",
+		},
+		EventRejected: {
+			Subject: "ValPago - Comprobante rechazado",
+			Body:    "🚨Comprobante no válido ❌❌❌⛓️‍💥📵\nMonto: {amount}\nReferencia: {reference}",
+		},
+	},
+	"en": {
+		EventApproved: {
+			Subject: "ValPago - Transaction approved",
+			Body:    "💲Transaction approved ✅✅✅🧾\nAmount: {amount}\nReference: {reference}",
+		},
+		EventRejected: {
+			Subject: "ValPago - Receipt rejected",
+			Body:    "🚨Invalid receipt ❌❌❌⛓️‍💥📵\nAmount: {amount}\nReference: {reference}",
+		},
+	},
+}
+
+// Vars son los valores que reemplazan los placeholders
+type Vars struct {
+	Amount        float64
+	Reference     string
+	Merchant      string
+	TransactionID string
+	Date          string
+	Reason        string
+}
+
+// SupportedLocale indica si hay plantillas para el idioma ("es", "es-CO", "en_US", ...)
+func SupportedLocale(locale string) bool {
+	_, ok := templates[baseLocale(locale)]
+	return ok
+}
+
+// Render arma el mensaje del evento. Busca primero en las plantillas propias del comercio
+// ("<evento>.<idioma>" y luego "<evento>") y después en las por defecto del idioma; un idioma
+// no soportado usa NOTIFY_DEFAULT_LOCALE.
+func Render(event, locale string, custom map[string]Template, v Vars) (Message, error) {
+	lang := baseLocale(locale)
+	if _, ok := templates[lang]; !ok {
+		lang = baseLocale(config.C.NotifyDefaultLocale)
+	}
+
+	t, ok := custom[event+"."+lang]
+	if !ok {
+		t, ok = custom[event]
+	}
+	if !ok || t.Body == "" {
+		if t, ok = templates[lang][event]; !ok {
+			return Message{}, fmt.Errorf("no template for event %s", event)
+		}
+	}
+
+	r := strings.NewReplacer(
+		"{amount}", FormatAmount(v.Amount, lang),
+		"{reference}", v.Reference,
+		"{merchant}", v.Merchant,
+		"{transaction_id}", v.TransactionID,
+		"{date}", v.Date,
+		"{reason}", v.Reason,
+	)
+	return Message{Subject: r.Replace(t.Subject), Body: r.Replace(t.Body)}, nil
+}
+
+// FormatAmount da formato de moneda según el idioma: "$50.000,50" en es y "$50,000.50" en en.
+// Los decimales solo se muestran si el monto los tiene.
+func FormatAmount(amount float64, locale string) string {
+	thousands, decimal := ".", ","
+	if baseLocale(locale) == "en" {
+		thousands, decimal = ",", "."
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	cents := int64(math.Round(amount * 100))
+	digits := strconv.FormatInt(cents/100, 10)
+
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(thousands)
+		}
+		b.WriteRune(d)
+	}
+	if cents%100 != 0 {
+		fmt.Fprintf(&b, "%s%02d", decimal, cents%100)
+	}
+	return sign + "$" + b.String()
+}
+
+func baseLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxResponseBody limita lo que se lee de la respuesta de un proveedor
+const maxResponseBody = 4096
+
+// Webhook publica {tel, msg} en una URL (p. ej. el flujo de n8n que reenvía por WhatsApp)
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	Tel     string `json:"tel"`
+	Msg     string `json:"msg"`
+	Subject string `json:"subject,omitempty"`
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (w *Webhook) Send(ctx context.Context, to string, msg Message) (*Response, error) {
+	body, err := json.Marshal(webhookPayload{Tel: to, Msg: msg.Body, Subject: msg.Subject})
+	if err != nil {
+		return nil, err
+	}
+	return postJSON(ctx, w.Client, w.URL, nil, body)
+}
+
+// postJSON hace el POST y devuelve la respuesta; los códigos no 2xx son error
+func postJSON(ctx context.Context, client *http.Client, endpoint string, header http.Header, body []byte) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// url.Error incluye la URL completa, que puede llevar credenciales (token de Telegram)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("%s %s: %w", urlErr.Op, req.URL.Host, urlErr.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	result := &Response{StatusCode: resp.StatusCode, Body: truncate(respBody, maxResponseBody)}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result, fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return result, nil
+}
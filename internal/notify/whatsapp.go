@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WhatsappCloud envía mensajes de texto con WhatsApp Cloud API (POST /<version>/<phone-number-id>/messages).
+// Meta solo entrega texto libre dentro de la ventana de 24 h desde el último mensaje del destinatario.
+type WhatsappCloud struct {
+	BaseURL       string
+	Version       string
+	PhoneNumberID string
+	Token         string
+	Client        *http.Client
+}
+
+type whatsappText struct {
+	MessagingProduct string `json:"messaging_product"`
+	To               string `json:"to"`
+	Type             string `json:"type"`
+	Text             struct {
+		Body string `json:"body"`
+	} `json:"text"`
+}
+
+func NewWhatsappCloud(baseURL, version, phoneNumberID, token string) *WhatsappCloud {
+	return &WhatsappCloud{
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		Version:       strings.Trim(version, "/"),
+		PhoneNumberID: phoneNumberID,
+		Token:         token,
+		Client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (w *WhatsappCloud) Send(ctx context.Context, to string, msg Message) (*Response, error) {
+	payload := whatsappText{MessagingProduct: "whatsapp", To: strings.TrimPrefix(to, "+"), Type: "text"}
+	payload.Text.Body = msg.Body
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+w.Token)
+	return postJSON(ctx, w.Client, fmt.Sprintf("%s/%s/%s/messages", w.BaseURL, w.Version, w.PhoneNumberID), header, body)
+}
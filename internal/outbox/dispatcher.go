@@ -0,0 +1,135 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/notify"
+	"github.com/usuario/valpago-backend/internal/webhooks"
+)
+
+// lease es cuánto tiempo un dispatcher se reserva un aviso; si muere a mitad de la entrega,
+// otro lo retoma al vencer
+const lease = 2 * time.Minute
+
+// Run entrega los avisos pendientes hasta que se cancele ctx. requeue (opcional) se llama en cada
+// vuelta para recuperar lo que quedó a medias por una caída (avisos sin encolar, eventos sin publicar).
+func Run(ctx context.Context, requeue func(ctx context.Context)) {
+	log.Println("Starting notification dispatcher...")
+	poll := time.Duration(config.C.NotifyPollSeconds) * time.Second
+	if poll <= 0 {
+		poll = 5 * time.Second
+	}
+
+	for {
+		if requeue != nil {
+			requeue(ctx)
+		}
+		for ctx.Err() == nil {
+			dispatched, err := DispatchNext(ctx)
+			if err != nil {
+				log.Printf("Notification dispatcher error: %v", err)
+				break
+			}
+			if !dispatched {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(poll):
+		}
+	}
+}
+
+// DispatchNext reserva el aviso vencido más antiguo y lo entrega. Devuelve false si no había ninguno.
+func DispatchNext(ctx context.Context) (bool, error) {
+	outbox := db.Mongo().Collection("notification_outbox")
+	now := time.Now()
+	// Milisegundos, la precisión con que Mongo guarda la fecha: luego se compara por igualdad
+	lockedUntil := now.Add(lease).Truncate(time.Millisecond)
+
+	var intent Intent
+	err := outbox.FindOneAndUpdate(ctx,
+		bson.M{
+			"status":        StatusPending,
+			"nextAttemptAt": bson.M{"$lte": now},
+			"$or": bson.A{
+				bson.M{"lockedUntil": nil},
+				bson.M{"lockedUntil": bson.M{"$lte": now}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{"lockedUntil": lockedUntil, "updatedAt": now},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&intent)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	resp, sendErr := deliver(ctx, &intent)
+	attempt := Attempt{
+		IntentID:      intent.ID,
+		TransactionID: intent.TransactionID,
+		MerchantID:    intent.MerchantID,
+		Channel:       intent.Destination.Channel,
+		To:            recipient(intent.Destination),
+		Event:         intent.Event,
+		LatencyMs:     time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		attempt.StatusCode, attempt.ResponseBody = resp.StatusCode, resp.Body
+	}
+	RecordAttempt(ctx, attempt, sendErr)
+
+	done := time.Now()
+	set := bson.M{"updatedAt": done, "lastStatusCode": attempt.StatusCode}
+	switch {
+	case sendErr == nil:
+		set["status"], set["deliveredAt"], set["lastError"] = StatusDelivered, done, ""
+	case intent.Attempts >= config.C.NotifyMaxAttempts || errors.Is(sendErr, webhooks.ErrDisabled) || errors.Is(sendErr, webhooks.ErrNotFound):
+		set["status"], set["lastError"] = StatusDead, sendErr.Error()
+		log.Printf("Notification %s moved to dead after %d attempts: %v", intent.ID.Hex(), intent.Attempts, sendErr)
+	default:
+		set["nextAttemptAt"], set["lastError"] = done.Add(backoff(intent.Attempts)), sendErr.Error()
+	}
+	// Solo si la reserva sigue siendo nuestra (no venció y la tomó otro dispatcher)
+	_, err = outbox.UpdateOne(ctx,
+		bson.M{"_id": intent.ID, "lockedUntil": lockedUntil},
+		bson.M{"$set": set, "$unset": bson.M{"lockedUntil": ""}},
+	)
+	return true, err
+}
+
+func deliver(ctx context.Context, intent *Intent) (*notify.Response, error) {
+	if intent.EndpointID != nil {
+		endpoint, err := webhooks.Load(ctx, *intent.EndpointID)
+		if err != nil {
+			return nil, err
+		}
+		return webhooks.Deliver(ctx, endpoint, intent.ID.Hex(), intent.Event, []byte(intent.Body))
+	}
+	n, err := notify.ForDestination(intent.Destination)
+	if err != nil {
+		return nil, err
+	}
+	return n.Send(ctx, intent.Destination.To, notify.Message{Subject: intent.Subject, Body: intent.Body})
+}
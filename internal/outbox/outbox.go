@@ -0,0 +1,212 @@
+// Package outbox guarda los avisos a comercios en MongoDB (colección notification_outbox) y los
+// entrega desde un dispatcher con reintentos. Cada intento queda en notification_attempts.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/notify"
+	"github.com/usuario/valpago-backend/internal/webhooks"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Statuses son los estados de un aviso
+var Statuses = []string{StatusPending, StatusDelivered, StatusDead}
+
+// maxStoredResponseBody limita lo que se guarda del body de respuesta de un intento
+const maxStoredResponseBody = 2048
+
+var (
+	ErrNotFound       = errors.New("notification not found")
+	ErrAlreadyPending = errors.New("notification is already pending delivery")
+)
+
+// Intent es un aviso por entregar a un destino de un comercio. El mensaje se arma al encolarlo,
+// así un cambio posterior en las plantillas no altera lo que se reenvía.
+type Intent struct {
+	ID primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	// Key evita encolar dos veces el mismo aviso (transacción, evento y destino)
+	Key           string             `json:"-" bson:"key"`
+	TransactionID primitive.ObjectID `json:"transactionId" bson:"transactionId"`
+	MerchantID    string             `json:"merchantId" bson:"merchantId"`
+	Event         string             `json:"event" bson:"event"`
+	Destination   notify.Destination `json:"destination" bson:"destination"`
+	// EndpointID es el endpoint firmado del comercio (ver webhooks); Body es entonces el evento JSON
+	EndpointID     *primitive.ObjectID `json:"endpointId,omitempty" bson:"endpointId,omitempty"`
+	Subject        string              `json:"subject,omitempty" bson:"subject,omitempty"`
+	Body           string              `json:"body" bson:"body"`
+	Status         string              `json:"status" bson:"status"`
+	Attempts       int                 `json:"attempts" bson:"attempts"`
+	Resends        int                 `json:"resends,omitempty" bson:"resends,omitempty"`
+	NextAttemptAt  time.Time           `json:"next_attempt_at" bson:"nextAttemptAt"`
+	LockedUntil    *time.Time          `json:"-" bson:"lockedUntil,omitempty"`
+	LastError      string              `json:"last_error,omitempty" bson:"lastError,omitempty"`
+	LastStatusCode int                 `json:"last_status_code,omitempty" bson:"lastStatusCode,omitempty"`
+	DeliveredAt    *time.Time          `json:"delivered_at,omitempty" bson:"deliveredAt,omitempty"`
+	CreatedAt      time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Attempt registra cada intento de avisar al comercio sobre una transacción (colección notification_attempts)
+type Attempt struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	IntentID      primitive.ObjectID `json:"notificationId,omitempty" bson:"intentId,omitempty"`
+	TransactionID primitive.ObjectID `json:"transactionId" bson:"transactionId"`
+	MerchantID    string             `json:"merchantId" bson:"merchantId"`
+	Channel       string             `json:"channel" bson:"channel"`
+	To            string             `json:"to" bson:"to"`
+	Event         string             `json:"event" bson:"event"`
+	Success       bool               `json:"success" bson:"success"`
+	StatusCode    int                `json:"status_code,omitempty" bson:"statusCode,omitempty"`
+	ResponseBody  string             `json:"response_body,omitempty" bson:"responseBody,omitempty"`
+	Error         string             `json:"error,omitempty" bson:"error,omitempty"`
+	LatencyMs     int64              `json:"latency_ms" bson:"latencyMs"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// NewIntent arma el aviso pendiente de un evento para un destino
+func NewIntent(txID primitive.ObjectID, merchantID, event string, d notify.Destination, msg notify.Message) Intent {
+	now := time.Now()
+	return Intent{
+		Key:           fmt.Sprintf("%s|%s|%s|%s", txID.Hex(), event, d.Channel, recipient(d)),
+		TransactionID: txID,
+		MerchantID:    merchantID,
+		Event:         event,
+		Destination:   d,
+		Subject:       msg.Subject,
+		Body:          msg.Body,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// NewWebhookIntent arma la entrega pendiente de un evento a un endpoint firmado del comercio.
+// El ID se fija aquí porque es el ID del evento que va dentro del payload.
+func NewWebhookIntent(id, txID primitive.ObjectID, merchantID, event string, endpoint *webhooks.Endpoint, payload []byte) Intent {
+	now := time.Now()
+	endpointID := endpoint.ID
+	return Intent{
+		ID:            id,
+		Key:           fmt.Sprintf("%s|%s|%s|%s", txID.Hex(), event, webhooks.Channel, endpoint.ID.Hex()),
+		TransactionID: txID,
+		MerchantID:    merchantID,
+		Event:         event,
+		Destination:   notify.Destination{Channel: webhooks.Channel, URL: endpoint.URL},
+		EndpointID:    &endpointID,
+		Body:          string(payload),
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// Enqueue guarda los avisos; los que ya estaban encolados (misma Key) se ignoran
+func Enqueue(ctx context.Context, intents ...Intent) error {
+	if len(intents) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(intents))
+	for i := range intents {
+		docs[i] = intents[i]
+	}
+	_, err := db.Mongo().Collection("notification_outbox").InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicateKeys(err) {
+		return err
+	}
+	return nil
+}
+
+// Resend vuelve a poner en cola un aviso entregado o en dead, con los intentos en cero
+func Resend(ctx context.Context, id primitive.ObjectID) (*Intent, error) {
+	now := time.Now()
+	var intent Intent
+	err := db.Mongo().Collection("notification_outbox").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": bson.M{"$ne": StatusPending}},
+		bson.M{
+			"$set":   bson.M{"status": StatusPending, "attempts": 0, "nextAttemptAt": now, "updatedAt": now},
+			"$inc":   bson.M{"resends": 1},
+			"$unset": bson.M{"lockedUntil": "", "deliveredAt": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&intent)
+	if err == mongo.ErrNoDocuments {
+		if n, _ := db.Mongo().Collection("notification_outbox").CountDocuments(ctx, bson.M{"_id": id}); n > 0 {
+			return nil, ErrAlreadyPending
+		}
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// RecordAttempt guarda el intento; un fallo al guardarlo solo se registra en el log
+func RecordAttempt(ctx context.Context, attempt Attempt, sendErr error) {
+	attempt.Success = sendErr == nil
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+	if len(attempt.ResponseBody) > maxStoredResponseBody {
+		attempt.ResponseBody = attempt.ResponseBody[:maxStoredResponseBody]
+	}
+	attempt.CreatedAt = time.Now()
+	if _, err := db.Mongo().Collection("notification_attempts").InsertOne(ctx, attempt); err != nil {
+		log.Printf("Failed to record notification attempt for transaction %s: %v", attempt.TransactionID.Hex(), err)
+	}
+}
+
+// backoff es la espera antes del intento siguiente: se duplica en cada fallo hasta NOTIFY_BACKOFF_MAX_SECONDS
+func backoff(attempts int) time.Duration {
+	base := time.Duration(config.C.NotifyBackoffSeconds) * time.Second
+	max := time.Duration(config.C.NotifyBackoffMaxSeconds) * time.Second
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// recipient es a quién se entrega: la URL propia en webhooks, si no el destinatario
+func recipient(d notify.Destination) string {
+	if (d.Channel == notify.ChannelWebhook || d.Channel == webhooks.Channel) && d.URL != "" {
+		return d.URL
+	}
+	return d.To
+}
+
+// onlyDuplicateKeys indica que todos los errores de un InsertMany son de clave duplicada
+func onlyDuplicateKeys(err error) bool {
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil {
+		return false
+	}
+	for _, e := range bulk.WriteErrors {
+		if e.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package password
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/usuario/valpago-backend/internal/config"
+)
+
+// bcrypt ignora todo lo que supere 72 bytes
+const maxBytes = 72
+
+//go:embed breached.txt
+var breachedList string
+
+var breached = func() map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, line := range strings.Split(breachedList, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		set[strings.ToLower(line)] = struct{}{}
+	}
+	return set
+}()
+
+var (
+	ErrTooLong  = errors.New("password must be at most 72 bytes")
+	ErrBreached = errors.New("password is too common, choose a different one")
+)
+
+// Validate aplica la política de contraseñas: longitud mínima (PASSWORD_MIN_LENGTH),
+// límite de bcrypt y que no esté en la lista de contraseñas filtradas incluida.
+func Validate(pw string) error {
+	if utf8.RuneCountInString(pw) < config.C.PasswordMinLength {
+		return fmt.Errorf("password must be at least %d characters", config.C.PasswordMinLength)
+	}
+	if len(pw) > maxBytes {
+		return ErrTooLong
+	}
+	if _, ok := breached[strings.ToLower(pw)]; ok {
+		return ErrBreached
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package receipts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/usuario/valpago-backend/internal/meta"
+)
+
+// ErrNotImage indica que lo descargado no es una imagen
+var ErrNotImage = errors.New("downloaded media is not an image")
+
+// isPermanent indica que reintentar no va a cambiar el resultado (media expirado, token inválido, no es imagen, ...)
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrNotImage) || !meta.IsRetryable(err)
+}
+
+// Image es un comprobante descargado
+type Image struct {
+	Data        []byte
+	ContentType string
+}
+
+// Fetch resuelve el media ID en Graph API y descarga la imagen
+func Fetch(ctx context.Context, mediaID string) (*Image, error) {
+	data, contentType, err := meta.Default().FetchMedia(ctx, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%w: %s", ErrNotImage, contentType)
+	}
+	return &Image{Data: data, ContentType: contentType}, nil
+}
@@ -0,0 +1,238 @@
+// Package receipts descarga los comprobantes de pago desde Meta, los guarda en el storage,
+// calcula su hash perceptual y registra el resultado en la transacción.
+// Lo usa el worker al consumir transaction.created.
+package receipts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/imagehash"
+	"github.com/usuario/valpago-backend/internal/meta"
+	"github.com/usuario/valpago-backend/internal/storage"
+)
+
+// Estados de la descarga del comprobante
+const (
+	FetchPending = "pending"
+	FetchOK      = "ok"
+	FetchFailed  = "failed"
+	FetchExpired = "expired"
+)
+
+const (
+	// maxSimilarReceipts limita cuántas transacciones similares se guardan por comprobante
+	maxSimilarReceipts = 20
+	// maxHashCandidates limita cuántos comprobantes con alguna banda en común se comparan
+	maxHashCandidates = 1000
+)
+
+// FetchState es el estado de la descarga del comprobante (campo receipt_fetch de la transacción)
+type FetchState struct {
+	Status    string    `json:"status" bson:"status"`
+	Attempts  int       `json:"attempts" bson:"attempts"`
+	Error     string    `json:"error,omitempty" bson:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Result es lo que quedó guardado en la transacción tras procesar el comprobante
+type Result struct {
+	Fetch           FetchState `json:"receipt_fetch"`
+	ReceiptHash     string     `json:"receipt_hash,omitempty"`
+	Suspect         bool       `json:"suspect"`
+	SimilarReceipts []string   `json:"similar_receipts,omitempty"`
+}
+
+// Pending es el estado inicial al crear la transacción
+func Pending() *FetchState {
+	return &FetchState{Status: FetchPending, UpdatedAt: time.Now()}
+}
+
+// Status devuelve el estado de la descarga guardado en la transacción ("" si no tiene)
+func Status(ctx context.Context, txID primitive.ObjectID) (string, error) {
+	var tx struct {
+		Fetch *FetchState `bson:"receipt_fetch"`
+	}
+	err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"_id": txID},
+		options.FindOne().SetProjection(bson.M{"receipt_fetch": 1}),
+	).Decode(&tx)
+	if err != nil {
+		return "", err
+	}
+	if tx.Fetch == nil {
+		return "", nil
+	}
+	return tx.Fetch.Status, nil
+}
+
+// Process descarga el comprobante (reintentando con backoff exponencial los errores transitorios,
+// hasta RECEIPT_FETCH_MAX_SECONDS en total), lo guarda en el storage y actualiza la transacción.
+// Nunca sustituye la imagen: si no se pudo obtener, receipt_fetch queda en failed o expired.
+// Si ctx se cancela no registra nada, para que el evento se reintente.
+func Process(ctx context.Context, txID primitive.ObjectID, mediaID string) (*Result, error) {
+	maxAttempts := config.C.ReceiptFetchMaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	backoff := time.Duration(config.C.ReceiptFetchBackoffSeconds) * time.Second
+
+	fetchCtx := ctx
+	if limit := time.Duration(config.C.ReceiptFetchMaxSeconds) * time.Second; limit > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
+	var (
+		img      *Image
+		err      error
+		attempts int
+	)
+	for attempts = 1; attempts <= maxAttempts; attempts++ {
+		img, err = Fetch(fetchCtx, mediaID)
+		if err == nil || isPermanent(err) || attempts == maxAttempts || ctx.Err() != nil {
+			break
+		}
+		wait := backoff << (attempts - 1)
+		var apiErr *meta.Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		// Sin tiempo para otro intento: queda como failed con el último error
+		if deadline, ok := fetchCtx.Deadline(); ok && time.Until(deadline) <= wait {
+			break
+		}
+		log.Printf("Receipt fetch for transaction %s failed (attempt %d/%d): %v", txID.Hex(), attempts, maxAttempts, err)
+		select {
+		case <-fetchCtx.Done():
+		case <-time.After(wait):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := &Result{Fetch: FetchState{Status: FetchOK, Attempts: attempts, UpdatedAt: time.Now()}}
+	set := bson.M{}
+	if err != nil {
+		result.Fetch.Status = FetchFailed
+		if errors.Is(err, meta.ErrMediaExpired) {
+			result.Fetch.Status = FetchExpired
+		}
+		result.Fetch.Error = err.Error()
+	} else {
+		key, err := store(ctx, txID, img)
+		if err != nil {
+			result.Fetch.Status = FetchFailed
+			result.Fetch.Error = err.Error()
+		} else {
+			set["receipt_key"] = key
+			hash, bands, similar, err := matchImage(ctx, txID, img.Data)
+			if err != nil {
+				log.Printf("Warning: Failed to hash receipt for transaction %s: %v", txID.Hex(), err)
+			} else {
+				result.ReceiptHash = hash
+				set["receipt_hash"], set["receipt_hash_bands"] = hash, bands
+				if len(similar) > 0 {
+					result.Suspect, result.SimilarReceipts = true, similar
+					set["suspect"], set["similar_receipts"] = true, similar
+				}
+			}
+		}
+	}
+	set["receipt_fetch"] = result.Fetch
+
+	if _, err := db.Mongo().Collection("transactions").UpdateOne(ctx, bson.M{"_id": txID}, bson.M{"$set": set}); err != nil {
+		return result, fmt.Errorf("updating transaction %s: %w", txID.Hex(), err)
+	}
+	return result, nil
+}
+
+// objectKey arma la llave del comprobante en el storage: receipts/<txID>/<nombre>
+func objectKey(txID, name string) string {
+	return path.Join("receipts", txID, name)
+}
+
+func store(ctx context.Context, txID primitive.ObjectID, img *Image) (string, error) {
+	s := storage.Default()
+	if s == nil {
+		return "", storage.ErrNotAvailable
+	}
+	ext := ".jpeg"
+	if exts, _ := mime.ExtensionsByType(img.ContentType); len(exts) > 0 {
+		ext = exts[0]
+	}
+	key := objectKey(txID.Hex(), fmt.Sprintf("evidence_%d%s", time.Now().UnixNano(), ext))
+	if err := s.Put(ctx, key, img.Data, img.ContentType); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// matchImage calcula el dHash del comprobante y busca transacciones (dentro de DUPLICATE_WINDOW_HOURS)
+// cuyo comprobante esté a una distancia de Hamming <= RECEIPT_HASH_MAX_DISTANCE. Se parte el hash en
+// RECEIPT_HASH_MAX_DISTANCE+1 bandas, así todo comprobante a esa distancia comparte alguna, y solo se
+// comparan esas (las más recientes, hasta maxHashCandidates). Las bandas llevan el número de bandas:
+// al cambiar RECEIPT_HASH_MAX_DISTANCE los comprobantes anteriores dejan de ser candidatos.
+func matchImage(ctx context.Context, id primitive.ObjectID, data []byte) (string, []string, []string, error) {
+	hash, err := imagehash.FromBytes(data)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	bands := hash.Bands(config.C.ReceiptHashMaxDistance + 1)
+
+	filter := bson.M{
+		"_id":                bson.M{"$ne": id},
+		"receipt_hash_bands": bson.M{"$in": bands},
+	}
+	if hours := config.C.DuplicateWindowHours; hours > 0 {
+		filter["createdAt"] = bson.M{"$gte": time.Now().Add(-time.Duration(hours) * time.Hour)}
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "receipt_hash": 1}).
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(maxHashCandidates)
+	cursor, err := db.Mongo().Collection("transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var similar []string
+	candidates := 0
+	for cursor.Next(ctx) && len(similar) < maxSimilarReceipts {
+		candidates++
+		var candidate struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			ReceiptHash string             `bson:"receipt_hash"`
+		}
+		if err := cursor.Decode(&candidate); err != nil {
+			continue
+		}
+		other, err := imagehash.Parse(candidate.ReceiptHash)
+		if err != nil {
+			continue
+		}
+		if imagehash.Distance(hash, other) <= config.C.ReceiptHashMaxDistance {
+			similar = append(similar, candidate.ID.Hex())
+		}
+	}
+	if candidates == maxHashCandidates {
+		log.Printf("Warning: receipt hash of transaction %s hit the %d candidate limit; older matches were not compared", id.Hex(), maxHashCandidates)
+	}
+	return hash.String(), bands, similar, cursor.Err()
+}
@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/worker"
+)
+
+const (
+	defaultDLQLimit = 50
+	maxDLQLimit     = 500
+)
+
+// listDLQ lista las entradas de <stream>:dlq, de la más reciente a la más antigua (?limit, ?before=<id>)
+func listDLQ(c echo.Context) error {
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+
+	limit := int64(defaultDLQLimit)
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 || n > maxDLQLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("limit must be between 1 and %d", maxDLQLimit)})
+		}
+		limit = n
+	}
+
+	ctx := c.Request().Context()
+	entries, err := worker.ListDLQ(ctx, limit, c.QueryParam("before"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read DLQ: " + err.Error()})
+	}
+	total, err := worker.DLQLength(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read DLQ length"})
+	}
+
+	next := ""
+	if int64(len(entries)) == limit {
+		next = entries[len(entries)-1].ID
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"stream":  worker.DLQStream(),
+		"entries": entries,
+		"pagination": map[string]interface{}{
+			"limit":  limit,
+			"total":  total,
+			"before": next,
+		},
+	})
+}
+
+func getDLQEntry(c echo.Context) error {
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+	entry, err := worker.GetDLQ(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return dlqError(c, err)
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// replayDLQEntry vuelve a encolar la entrada en el stream de procesamiento y la quita de la DLQ
+func replayDLQEntry(c echo.Context) error {
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+	id, err := worker.ReplayDLQ(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return dlqError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "Entry replayed", "id": id})
+}
+
+func deleteDLQEntry(c echo.Context) error {
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+	if err := worker.DeleteDLQ(c.Request().Context(), c.Param("id")); err != nil {
+		return dlqError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Entry deleted"})
+}
+
+func dlqError(c echo.Context, err error) error {
+	if errors.Is(err, worker.ErrDLQEntryNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "DLQ entry not found"})
+	}
+	// Un ID con formato inválido lo rechaza Redis
+	return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+}
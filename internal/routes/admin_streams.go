@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/streams"
+	"github.com/usuario/valpago-backend/internal/worker"
+)
+
+// streamMetrics informa tamaño, antigüedad, pendientes y lag de los streams de Redis,
+// y el estado del archivado en la colección events
+func streamMetrics(c echo.Context) error {
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+
+	ctx := c.Request().Context()
+	result := []*streams.Stats{}
+	for _, name := range []string{config.C.RedisStreamNS, config.C.RedisNotificationsStream, worker.DLQStream()} {
+		stats, err := streams.Info(ctx, name)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read stream " + name})
+		}
+		result = append(result, stats)
+	}
+
+	archived, err := db.Mongo().Collection("events").EstimatedDocumentCount(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to count archived events"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"streams": result,
+		"archive": map[string]interface{}{
+			"retention_hours": config.C.StreamRetentionHours,
+			"archived_events": archived,
+			// Solo en el proceso que corre el worker
+			"last_run": streams.LastRun(),
+		},
+	})
+}
@@ -0,0 +1,288 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+const (
+	apiKeyPrefix            = "vp"
+	ScopeTransactionsCreate = "transactions:create"
+)
+
+// apiKeyContextKey es la clave con la que requireAPIKey guarda la APIKey en el contexto
+const apiKeyContextKey = "apiKey"
+
+var validScopes = []string{ScopeTransactionsCreate}
+
+// APIKey representa una clave emitida a un comercio/integración (p. ej. el flujo de n8n).
+// Solo se guarda el hash SHA-256 de la clave; el valor en claro se devuelve una única vez.
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	Hash       string             `json:"-" bson:"hash"`
+	UserID     string             `json:"userId" bson:"userId"`
+	MerchantID string             `json:"merchantId,omitempty" bson:"merchantId,omitempty"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	ExpiresAt  *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	CreatedBy  string             `json:"createdBy" bson:"createdBy"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	RotatedAt  *time.Time         `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	UserID        string   `json:"userId"`
+	MerchantID    string   `json:"merchantId"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// APIKeyResponse incluye la clave en claro; solo se devuelve al crear o rotar
+type APIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"apiKey"`
+}
+
+// generateAPIKey crea una clave con formato vp_<prefix>_<secret> y devuelve (clave, prefix, hash)
+func generateAPIKey() (string, string, string, error) {
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	key := apiKeyPrefix + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+	return key, prefix, sha256Hex(key), nil
+}
+
+func sha256Hex(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireAPIKey valida la API key del header configurado (API_KEY_HEADER_NAME) y exige el scope indicado
+func requireAPIKey(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(config.C.APIKeyHeader)
+			if key == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key required"})
+			}
+
+			parts := strings.SplitN(key, "_", 3)
+			if len(parts) != 3 || parts[0] != apiKeyPrefix {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid API key"})
+			}
+
+			ctx := c.Request().Context()
+			var apiKey APIKey
+			err := db.Mongo().Collection("api_keys").FindOne(ctx, bson.M{"prefix": parts[1]}).Decode(&apiKey)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid API key"})
+				}
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			}
+			if subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(sha256Hex(key))) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid API key"})
+			}
+
+			now := time.Now()
+			if apiKey.RevokedAt != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key revoked"})
+			}
+			if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key expired"})
+			}
+			if !contains(apiKey.Scopes, scope) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "API key missing scope " + scope})
+			}
+
+			_, _ = db.Mongo().Collection("api_keys").UpdateOne(ctx, bson.M{"_id": apiKey.ID}, bson.M{"$set": bson.M{"lastUsedAt": now}})
+			apiKey.LastUsedAt = &now
+
+			c.Set(apiKeyContextKey, &apiKey)
+			return next(c)
+		}
+	}
+}
+
+// currentAPIKey devuelve la API key autenticada por requireAPIKey
+func currentAPIKey(c echo.Context) *APIKey {
+	apiKey, _ := c.Get(apiKeyContextKey).(*APIKey)
+	return apiKey
+}
+
+func createAPIKey(c echo.Context) error {
+	var req CreateAPIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.Name == "" || req.UserID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields: name, userId"})
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{ScopeTransactionsCreate}
+	}
+	for _, s := range req.Scopes {
+		if !contains(validScopes, s) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid scope: " + s})
+		}
+	}
+
+	ctx := c.Request().Context()
+
+	// El usuario dueño de las transacciones creadas con la clave debe existir
+	userID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	if err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"_id": userID}).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	}
+
+	if req.MerchantID != "" {
+		merchantID, err := primitive.ObjectIDFromHex(req.MerchantID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid merchant ID"})
+		}
+		if err := db.Mongo().Collection("merchants").FindOne(ctx, bson.M{"_id": merchantID}).Err(); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "Merchant not found"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		}
+	}
+
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate API key"})
+	}
+
+	apiKey := APIKey{
+		Name:       req.Name,
+		Prefix:     prefix,
+		Hash:       hash,
+		UserID:     req.UserID,
+		MerchantID: req.MerchantID,
+		Scopes:     req.Scopes,
+		CreatedAt:  time.Now(),
+	}
+	if claims := currentClaims(c); claims != nil {
+		apiKey.CreatedBy = claims.UserID
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	result, err := db.Mongo().Collection("api_keys").InsertOne(ctx, apiKey)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create API key"})
+	}
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+
+	return c.JSON(http.StatusCreated, APIKeyResponse{Key: key, APIKey: apiKey})
+}
+
+func listAPIKeys(c echo.Context) error {
+	filter := bson.M{}
+	if userID := c.QueryParam("userId"); userID != "" {
+		filter["userId"] = userID
+	}
+	if merchantID := c.QueryParam("merchantId"); merchantID != "" {
+		filter["merchantId"] = merchantID
+	}
+	if c.QueryParam("includeRevoked") != "true" {
+		filter["revokedAt"] = bson.M{"$exists": false}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := db.Mongo().Collection("api_keys").Find(c.Request().Context(), filter, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+	}
+	defer cursor.Close(c.Request().Context())
+
+	apiKeys := []APIKey{}
+	if err = cursor.All(c.Request().Context(), &apiKeys); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode API keys"})
+	}
+
+	return c.JSON(http.StatusOK, apiKeys)
+}
+
+// rotateAPIKey reemplaza el secreto (y el prefix) de una clave; la clave anterior deja de funcionar de inmediato
+func rotateAPIKey(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid API key ID"})
+	}
+
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate API key"})
+	}
+
+	var apiKey APIKey
+	err = db.Mongo().Collection("api_keys").FindOneAndUpdate(
+		c.Request().Context(),
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"prefix": prefix, "hash": hash, "rotatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rotate API key"})
+	}
+
+	return c.JSON(http.StatusOK, APIKeyResponse{Key: key, APIKey: apiKey})
+}
+
+func revokeAPIKey(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid API key ID"})
+	}
+
+	result, err := db.Mongo().Collection("api_keys").UpdateOne(
+		c.Request().Context(),
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke API key"})
+	}
+	if result.MatchedCount == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "API key revoked successfully"})
+}
@@ -1,7 +1,9 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/usuario/valpago-backend/internal/config"
 	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/jwtkeys"
 )
 
 type LoginRequest struct {
@@ -20,15 +23,19 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // segundos de vida del access token
+	User         User   `json:"user"`
 }
 
 type JWTClaims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
-	Phone  string `json:"phone"`
-	Role   string `json:"role"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	Purpose   string `json:"purpose,omitempty"` // vacío en access tokens; "mfa" en el challenge de login
 	jwt.RegisteredClaims
 }
 
@@ -38,11 +45,22 @@ func login(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 
+	ctx := c.Request().Context()
+	ip := c.RealIP()
+
+	if wait := loginLockedFor(ctx, req.Email, ip); wait > 0 {
+		auditLogin(c, req.Email, "", false, "locked")
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Too many failed attempts, try again later"})
+	}
+
 	// Find user by email
 	var user User
-	err := db.Mongo().Collection("users").FindOne(c.Request().Context(), bson.M{"email": req.Email}).Decode(&user)
+	err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"email": req.Email}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
+			recordLoginFailure(ctx, req.Email, ip)
+			auditLogin(c, req.Email, "", false, "unknown_user")
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
 		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
@@ -51,31 +69,90 @@ func login(c echo.Context) error {
 	// Verify password hash
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		recordLoginFailure(ctx, req.Email, ip)
+		auditLogin(c, req.Email, user.ID.Hex(), false, "bad_password")
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
 	}
 
-	// Create JWT token
-	claims := &JWTClaims{
-		UserID: user.ID.Hex(),
-		Email:  user.Email,
-		Phone:  user.Phone,
-		Role:   user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(config.C.JWTExpHours) * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	if !user.IsActive {
+		auditLogin(c, req.Email, user.ID.Hex(), false, "inactive")
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Account is disabled"})
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(config.C.JWTSecret))
+	clearLoginFailures(ctx, req.Email)
+
+	// Segundo factor: si está activo, o si el rol lo exige, el login continúa en /auth/mfa/*
+	if user.MFAEnabled {
+		return mfaChallenge(c, user, false)
+	}
+	if mfaRequiredForRole(ctx, user.Role) {
+		return mfaChallenge(c, user, true)
+	}
+
+	resp, err := issueSession(c, user)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create token"})
 	}
+	auditLogin(c, req.Email, user.ID.Hex(), true, "")
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// signAccessToken firma un access token de vida corta ligado a la sesión sid
+func signAccessToken(user User, sid string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &JWTClaims{
+		UserID:    user.ID.Hex(),
+		Email:     user.Email,
+		Phone:     user.Phone,
+		Role:      user.Role,
+		SessionID: sid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    config.C.JWTIssuer,
+			Subject:   user.ID.Hex(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL())),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
 
-	user.Password = "" // Don't return password
+	return jwtkeys.Sign(claims)
+}
+
+func accessTokenTTL() time.Duration {
+	return time.Duration(config.C.JWTAccessTTLMinutes) * time.Minute
+}
 
-	return c.JSON(http.StatusOK, LoginResponse{
-		Token: tokenString,
-		User:  user,
-	})
+// jwks publica las llaves públicas de firma para que otros servicios verifiquen los tokens de ValPago
+func jwks(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, jwtkeys.JWKS())
+}
+
+// parseToken valida la firma y expiración de un access token y devuelve sus claims
+func parseToken(tokenString string) (*JWTClaims, error) {
+	return parseTokenWithPurpose(tokenString, "")
+}
+
+// parseTokenWithPurpose valida un token y exige el propósito indicado ("" para access tokens)
+func parseTokenWithPurpose(tokenString, purpose string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, jwtkeys.Keyfunc,
+		jwt.WithValidMethods(jwtkeys.ValidMethods()),
+		jwt.WithIssuer(config.C.JWTIssuer),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if claims.Purpose != purpose {
+		return nil, errors.New("unexpected token purpose")
+	}
+	return claims, nil
 }
@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// UpdateDraftRequest completa los datos de una transacción draft; los campos vacíos no se modifican
+type UpdateDraftRequest struct {
+	PaymentMethod      string   `json:"payment_method"`
+	Amount             *float64 `json:"amount"`
+	DestinationAccount string   `json:"destination_account"`
+	Reference          string   `json:"reference"`
+	SourceAccount      string   `json:"source_account"`
+	Beneficiary        string   `json:"beneficiary"`
+	Date               string   `json:"date"`
+}
+
+// updateDraft edita una transacción mientras está en draft
+func updateDraft(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
+	}
+
+	var req UpdateDraftRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	for field, value := range map[string]string{
+		"payment_method":      req.PaymentMethod,
+		"destination_account": req.DestinationAccount,
+		"reference":           req.Reference,
+		"source_account":      req.SourceAccount,
+		"beneficiary":         req.Beneficiary,
+		"date":                req.Date,
+	} {
+		if value != "" {
+			set[field] = value
+		}
+	}
+	if req.Amount != nil {
+		if *req.Amount <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "amount must be greater than 0"})
+		}
+		set["amount"] = *req.Amount
+	}
+
+	// Condicional al estado: una vez enviada a pending ya no se edita
+	var tx Transaction
+	err = db.Mongo().Collection("transactions").FindOneAndUpdate(c.Request().Context(),
+		bson.M{"_id": id, "status": StatusDraft},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&tx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return transitionError(c, draftStateError(c, id))
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update transaction"})
+	}
+
+	return c.JSON(http.StatusOK, tx)
+}
+
+// submitDraft envía el draft a la cola de revisión (draft -> pending)
+func submitDraft(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
+	}
+
+	var req TransitionRequest
+	_ = c.Bind(&req)
+
+	tx, err := applyTransition(c.Request().Context(), id, StatusPending, actorFromContext(c), req.Reason)
+	if err != nil {
+		return transitionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tx)
+}
+
+// draftStateError distingue transacción inexistente de transacción que ya no está en draft
+func draftStateError(c echo.Context, id primitive.ObjectID) error {
+	var current Transaction
+	if err := db.Mongo().Collection("transactions").FindOne(c.Request().Context(), bson.M{"_id": id}).Decode(&current); err != nil {
+		return ErrTransactionNotFound
+	}
+	return &InvalidTransitionError{From: current.Status, To: StatusDraft}
+}
@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// maxDuplicateCandidates limita cuántas transacciones previas se comparan por fecha
+const maxDuplicateCandidates = 50
+
+// receiptDateLayouts son los formatos de fecha que llegan en el comprobante
+var receiptDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+	"02/01/2006",
+	"02-01-2006",
+}
+
+// findDuplicateReceipt busca una transacción previa con la misma referencia, cuenta de origen y monto,
+// creada dentro de DUPLICATE_WINDOW_HOURS y con fecha de comprobante a no más de DUPLICATE_DATE_TOLERANCE_HOURS.
+// Devuelve la transacción original (la primera registrada) o nil.
+func findDuplicateReceipt(ctx context.Context, tx *Transaction) (*Transaction, error) {
+	if strings.TrimSpace(tx.Reference) == "" {
+		return nil, nil
+	}
+
+	filter := bson.M{
+		"reference":      tx.Reference,
+		"source_account": tx.SourceAccount,
+		"amount":         tx.Amount,
+	}
+	if !tx.ID.IsZero() {
+		filter["_id"] = bson.M{"$ne": tx.ID}
+	}
+	if hours := config.C.DuplicateWindowHours; hours > 0 {
+		filter["createdAt"] = bson.M{"$gte": time.Now().Add(-time.Duration(hours) * time.Hour)}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(maxDuplicateCandidates)
+	cursor, err := db.Mongo().Collection("transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var candidates []Transaction
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	tolerance := time.Duration(config.C.DuplicateDateToleranceHours) * time.Hour
+	for i := range candidates {
+		if similarReceiptDates(tx.Date, candidates[i].Date, tolerance) {
+			return &candidates[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// similarReceiptDates compara las fechas de dos comprobantes; si alguna no se puede interpretar
+// solo coinciden cuando el texto es igual (o alguna falta)
+func similarReceiptDates(a, b string, tolerance time.Duration) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if a == "" || b == "" || strings.EqualFold(a, b) {
+		return true
+	}
+	ta, okA := parseReceiptDate(a)
+	tb, okB := parseReceiptDate(b)
+	if !okA || !okB {
+		return false
+	}
+	diff := ta.Sub(tb)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
+
+func parseReceiptDate(v string) (time.Time, bool) {
+	for _, layout := range receiptDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
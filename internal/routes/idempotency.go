@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+const (
+	idempotencyHeader       = "Idempotency-Key"
+	idempotencyMaxKeyLength = 255
+	idempotencyInProgress   = "in_progress"
+	idempotencyCompleted    = "completed"
+	// idempotencyContextKey guarda "<scope>:<key>" para que el handler lo registre en lo que crea
+	idempotencyContextKey = "idempotency_key"
+)
+
+// IdempotencyRecord guarda la respuesta de un request con Idempotency-Key (colección idempotency_keys).
+// La unicidad es por (scope, key): el scope es la API key que hizo el request.
+type IdempotencyRecord struct {
+	Scope       string    `bson:"scope"`
+	Key         string    `bson:"key"`
+	RequestHash string    `bson:"requestHash"`
+	State       string    `bson:"state"`
+	StatusCode  int       `bson:"statusCode,omitempty"`
+	ContentType string    `bson:"contentType,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	LockedAt    time.Time `bson:"lockedAt,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+// recordingWriter copia lo que escribe el handler para poder guardarlo
+type recordingWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// requireIdempotency hace que reintentos con el mismo Idempotency-Key devuelvan la respuesta original
+// en lugar de volver a ejecutar el handler. Debe ir después de requireAPIKey.
+// Sin header el request se procesa normalmente.
+func requireIdempotency(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(idempotencyHeader)
+		if key == "" {
+			return next(c)
+		}
+		if len(key) > idempotencyMaxKeyLength {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Idempotency-Key is too long"})
+		}
+
+		scope := "anonymous"
+		if apiKey := currentAPIKey(c); apiKey != nil {
+			scope = apiKey.ID.Hex()
+		}
+
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+		requestHash := sha256Hex(c.Request().Method + " " + c.Path() + "\n" + string(body))
+
+		ctx := c.Request().Context()
+		keys := db.Mongo().Collection("idempotency_keys")
+		now := time.Now()
+		_, err = keys.InsertOne(ctx, IdempotencyRecord{
+			Scope:       scope,
+			Key:         key,
+			RequestHash: requestHash,
+			State:       idempotencyInProgress,
+			LockedAt:    now,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(time.Duration(config.C.IdempotencyTTLHours) * time.Hour),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			var existing IdempotencyRecord
+			if err := keys.FindOne(ctx, bson.M{"scope": scope, "key": key}).Decode(&existing); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			}
+			if existing.RequestHash != requestHash {
+				return c.JSON(http.StatusConflict, map[string]string{"error": "Idempotency-Key was already used with a different request"})
+			}
+			if existing.State == idempotencyCompleted {
+				c.Response().Header().Set("Idempotent-Replayed", "true")
+				return c.Blob(existing.StatusCode, existing.ContentType, existing.Body)
+			}
+			taken, err := takeOverStaleLock(ctx, keys, &existing, now)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+			}
+			if !taken {
+				return c.JSON(http.StatusConflict, map[string]string{"error": "A request with this Idempotency-Key is still being processed"})
+			}
+			log.Printf("Taking over stale idempotency lock for key %s", key)
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		}
+
+		c.Set(idempotencyContextKey, scope+":"+key)
+		recorder := &recordingWriter{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = recorder
+		handlerErr := next(c)
+		c.Response().Writer = recorder.ResponseWriter
+
+		// El resultado se guarda aunque el cliente ya se haya desconectado: si no, el registro queda
+		// in_progress y al vencer el lock un reintento volvería a ejecutar el handler
+		ctx = context.WithoutCancel(ctx)
+		filter := bson.M{"scope": scope, "key": key}
+		status := c.Response().Status
+		if handlerErr != nil || status >= http.StatusInternalServerError {
+			// Los errores del servidor no se guardan: el cliente puede reintentar con la misma llave
+			if _, err := keys.DeleteOne(ctx, filter); err != nil {
+				log.Printf("Failed to release idempotency key %s: %v", key, err)
+			}
+			return handlerErr
+		}
+		_, err = keys.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"state":       idempotencyCompleted,
+			"statusCode":  status,
+			"contentType": c.Response().Header().Get(echo.HeaderContentType),
+			"body":        recorder.body.Bytes(),
+		}})
+		if err != nil {
+			log.Printf("Failed to store idempotent response for key %s: %v", key, err)
+		}
+		return nil
+	}
+}
+
+// currentIdempotencyKey devuelve "<scope>:<key>" del request ("" si no trae Idempotency-Key)
+func currentIdempotencyKey(c echo.Context) string {
+	key, _ := c.Get(idempotencyContextKey).(string)
+	return key
+}
+
+// takeOverStaleLock retoma un in_progress que lleva más de IDEMPOTENCY_LOCK_SECONDS bloqueado (el proceso
+// que lo tomó murió a mitad del request). El update es condicional sobre lockedAt para que solo un
+// reintento concurrente lo consiga. Si el request original alcanzó a crear la transacción, el handler
+// la encuentra por su idempotency_key y la devuelve en lugar de crear otra.
+func takeOverStaleLock(ctx context.Context, keys *mongo.Collection, existing *IdempotencyRecord, now time.Time) (bool, error) {
+	lockedAt := existing.LockedAt
+	if lockedAt.IsZero() {
+		// Registros anteriores a lockedAt
+		lockedAt = existing.CreatedAt
+	}
+	if now.Sub(lockedAt) < time.Duration(config.C.IdempotencyLockSeconds)*time.Second {
+		return false, nil
+	}
+
+	filter := bson.M{"scope": existing.Scope, "key": existing.Key, "state": idempotencyInProgress}
+	if existing.LockedAt.IsZero() {
+		filter["lockedAt"] = bson.M{"$exists": false}
+	} else {
+		filter["lockedAt"] = existing.LockedAt
+	}
+	res, err := keys.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lockedAt": now}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// indexes lista los índices que necesitan las rutas, por colección
+var indexes = map[string][]mongo.IndexModel{
+	"api_keys": {
+		{Keys: bson.D{{Key: "prefix", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "merchantId", Value: 1}}},
+	},
+	"idempotency_keys": {
+		{Keys: bson.D{{Key: "scope", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"events": {
+		{Keys: bson.D{{Key: "stream", Value: 1}, {Key: "entryId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "transactionId", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdAt", Value: -1}}},
+	},
+	"login_audit": {
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	},
+	// Solo una instancia puede insertar la sucesora de cada llave (las anteriores no tienen after)
+	"signing_keys": {
+		{
+			Keys:    bson.D{{Key: "alg", Value: 1}, {Key: "after", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"after": bson.M{"$exists": true}}),
+		},
+	},
+	"transactions": {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "amount", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "destination_account", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "merchantId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "payment_method", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "reference", Value: 1}}},
+		{Keys: bson.D{{Key: "reference", Value: 1}, {Key: "source_account", Value: 1}, {Key: "amount", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "duplicate_of", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "whatsapp_message_id", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "receipt_hash_bands", Value: 1}, {Key: "createdAt", Value: -1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "notification_pending", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "event_pending", Value: 1}}, Options: options.Index().SetSparse(true)},
+	},
+	"notification_attempts": {
+		{Keys: bson.D{{Key: "transactionId", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "intentId", Value: 1}, {Key: "createdAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+	},
+	"merchant_webhooks": {
+		{Keys: bson.D{{Key: "merchantId", Value: 1}, {Key: "disabled", Value: 1}}},
+	},
+	"notification_outbox": {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "transactionId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "merchantId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	},
+	"sessions": {
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+}
+
+// EnsureIndexes crea (si no existen) los índices de MongoDB usados por la API
+func EnsureIndexes(ctx context.Context) error {
+	for collection, models := range indexes {
+		if _, err := db.Mongo().Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating indexes for %s: %w", collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// failureCounterTTL es cuánto se recuerdan los intentos fallidos (permite escalar el bloqueo)
+const failureCounterTTL = 24 * time.Hour
+
+// LoginAudit es un registro de intento de login (exitoso o no)
+type LoginAudit struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Email     string             `json:"email" bson:"email"`
+	UserID    string             `json:"userId,omitempty" bson:"userId,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"userAgent" bson:"userAgent"`
+	Success   bool               `json:"success" bson:"success"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+type UnlockRequest struct {
+	IP string `json:"ip"`
+}
+
+// loginSubject es una dimensión de throttling: por email o por IP
+type loginSubject struct {
+	kind  string
+	value string
+	max   int
+}
+
+func loginSubjects(email, ip string) []loginSubject {
+	return []loginSubject{
+		{kind: "email", value: normalizeEmail(email), max: config.C.LoginMaxAttempts},
+		{kind: "ip", value: ip, max: config.C.LoginMaxAttemptsIP},
+	}
+}
+
+func (s loginSubject) failKey() string { return fmt.Sprintf("auth:fail:%s:%s", s.kind, s.value) }
+func (s loginSubject) lockKey() string { return fmt.Sprintf("auth:lock:%s:%s", s.kind, s.value) }
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginLockedFor devuelve el tiempo restante del bloqueo más largo vigente (0 si no hay bloqueo)
+func loginLockedFor(ctx context.Context, email, ip string) time.Duration {
+	if db.Rdb == nil {
+		return 0
+	}
+	var longest time.Duration
+	for _, s := range loginSubjects(email, ip) {
+		ttl, err := db.Rdb.PTTL(ctx, s.lockKey()).Result()
+		if err != nil {
+			continue
+		}
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// recordLoginFailure incrementa los contadores y, superado el máximo, bloquea
+// con backoff exponencial: base * 2^(fallos-máximo), hasta LOGIN_LOCKOUT_MAX_SECONDS
+func recordLoginFailure(ctx context.Context, email, ip string) {
+	if db.Rdb == nil {
+		return
+	}
+	for _, s := range loginSubjects(email, ip) {
+		n, err := db.Rdb.Incr(ctx, s.failKey()).Result()
+		if err != nil {
+			log.Printf("Failed to record login failure for %s: %v", s.kind, err)
+			continue
+		}
+		db.Rdb.Expire(ctx, s.failKey(), failureCounterTTL)
+
+		if int(n) < s.max {
+			continue
+		}
+		lockout := lockoutDuration(int(n) - s.max)
+		db.Rdb.Set(ctx, s.lockKey(), strconv.FormatInt(n, 10), lockout)
+	}
+}
+
+func lockoutDuration(excess int) time.Duration {
+	max := time.Duration(config.C.LoginLockoutMaxSeconds) * time.Second
+	d := time.Duration(config.C.LoginLockoutBaseSeconds) * time.Second
+	for i := 0; i < excess && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// clearLoginFailures limpia contadores y bloqueo del email. El contador por IP no se
+// reinicia con un login exitoso para no premiar el credential stuffing.
+func clearLoginFailures(ctx context.Context, email string) {
+	if db.Rdb == nil {
+		return
+	}
+	s := loginSubject{kind: "email", value: normalizeEmail(email)}
+	db.Rdb.Del(ctx, s.failKey(), s.lockKey())
+}
+
+func auditLogin(c echo.Context, email, userID string, success bool, reason string) {
+	entry := LoginAudit{
+		Email:     normalizeEmail(email),
+		UserID:    userID,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Success:   success,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.Mongo().Collection("login_audit").InsertOne(c.Request().Context(), entry); err != nil {
+		log.Printf("Failed to write login audit: %v", err)
+	}
+}
+
+// unlockUser limpia los bloqueos de login y de recuperación de contraseña de un usuario (admin).
+// Opcionalmente también los de una IP.
+func unlockUser(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	var req UnlockRequest
+	_ = c.Bind(&req)
+
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+
+	ctx := c.Request().Context()
+	var user User
+	if err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch user"})
+	}
+
+	clearLoginFailures(ctx, user.Email)
+	db.Rdb.Del(ctx, resetFailuresKey(user.ID.Hex()))
+	if req.IP != "" {
+		s := loginSubject{kind: "ip", value: req.IP}
+		db.Rdb.Del(ctx, s.failKey(), s.lockKey())
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "User unlocked successfully"})
+}
@@ -0,0 +1,307 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/outbox"
+	"github.com/usuario/valpago-backend/internal/webhooks"
+)
+
+type WebhookEndpointRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+	Disabled    bool     `json:"disabled"`
+}
+
+// WebhookEndpointResponse incluye el secreto en claro; solo se devuelve al crear o rotar
+type WebhookEndpointResponse struct {
+	Secret   string             `json:"secret"`
+	Endpoint *webhooks.Endpoint `json:"endpoint"`
+}
+
+// webhookEventData es el "data" de los eventos de transacción que reciben los comercios
+type webhookEventData struct {
+	Transaction webhookTransaction `json:"transaction"`
+}
+
+type webhookTransaction struct {
+	ID                 string    `json:"id"`
+	Status             string    `json:"status"`
+	Amount             float64   `json:"amount"`
+	Reference          string    `json:"reference"`
+	PaymentMethod      string    `json:"payment_method"`
+	DestinationAccount string    `json:"destination_account"`
+	SourceAccount      string    `json:"source_account"`
+	Date               string    `json:"date"`
+	Reason             string    `json:"reason,omitempty"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// webhookIntents arma las entregas firmadas del evento para los endpoints suscritos del comercio
+func webhookIntents(ctx context.Context, merchant *Merchant, tx *Transaction, event, reason string) ([]outbox.Intent, error) {
+	endpoints, err := webhooks.ForEvent(ctx, merchant.ID, event)
+	if err != nil || len(endpoints) == 0 {
+		return nil, err
+	}
+	data := webhookEventData{Transaction: webhookTransaction{
+		ID:                 tx.ID.Hex(),
+		Status:             tx.Status,
+		Amount:             tx.Amount,
+		Reference:          tx.Reference,
+		PaymentMethod:      tx.PaymentMethod,
+		DestinationAccount: tx.DestinationAccount,
+		SourceAccount:      tx.SourceAccount,
+		Date:               tx.Date,
+		Reason:             reason,
+		UpdatedAt:          tx.UpdatedAt,
+	}}
+
+	var intents []outbox.Intent
+	for i := range endpoints {
+		id := primitive.NewObjectID()
+		payload, err := webhooks.NewEvent(id.Hex(), event, data)
+		if err != nil {
+			return nil, err
+		}
+		intents = append(intents, outbox.NewWebhookIntent(id, tx.ID, merchant.ID.Hex(), event, &endpoints[i], payload))
+	}
+	return intents, nil
+}
+
+// createMerchantWebhook registra un endpoint y devuelve su secreto (única vez que se muestra)
+func createMerchantWebhook(c echo.Context) error {
+	merchantID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid merchant ID"})
+	}
+	var req WebhookEndpointRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if err := validateWebhookRequest(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	ctx := c.Request().Context()
+	if err := db.Mongo().Collection("merchants").FindOne(ctx, bson.M{"_id": merchantID}).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Merchant not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	}
+
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate secret"})
+	}
+	now := time.Now()
+	endpoint := webhooks.Endpoint{
+		MerchantID:  merchantID,
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		Disabled:    req.Disabled,
+		Secret:      secret,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	result, err := db.Mongo().Collection("merchant_webhooks").InsertOne(ctx, endpoint)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create webhook"})
+	}
+	endpoint.ID = result.InsertedID.(primitive.ObjectID)
+
+	return c.JSON(http.StatusCreated, WebhookEndpointResponse{Secret: secret, Endpoint: &endpoint})
+}
+
+func listMerchantWebhooks(c echo.Context) error {
+	merchantID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid merchant ID"})
+	}
+
+	ctx := c.Request().Context()
+	cursor, err := db.Mongo().Collection("merchant_webhooks").Find(ctx,
+		bson.M{"merchantId": merchantID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch webhooks"})
+	}
+	defer cursor.Close(ctx)
+
+	endpoints := []webhooks.Endpoint{}
+	if err := cursor.All(ctx, &endpoints); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode webhooks"})
+	}
+	return c.JSON(http.StatusOK, endpoints)
+}
+
+func updateMerchantWebhook(c echo.Context) error {
+	merchantID, webhookID, ok := merchantWebhookIDs(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid merchant or webhook ID"})
+	}
+	var req WebhookEndpointRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if err := validateWebhookRequest(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var endpoint webhooks.Endpoint
+	err := db.Mongo().Collection("merchant_webhooks").FindOneAndUpdate(c.Request().Context(),
+		bson.M{"_id": webhookID, "merchantId": merchantID},
+		bson.M{"$set": bson.M{
+			"url":         req.URL,
+			"events":      req.Events,
+			"description": req.Description,
+			"disabled":    req.Disabled,
+			"updatedAt":   time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&endpoint)
+	if err != nil {
+		return merchantWebhookError(c, err)
+	}
+	return c.JSON(http.StatusOK, endpoint)
+}
+
+// deleteMerchantWebhook elimina el endpoint; las entregas pendientes pasan a dead en su próximo intento
+func deleteMerchantWebhook(c echo.Context) error {
+	merchantID, webhookID, ok := merchantWebhookIDs(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid merchant or webhook ID"})
+	}
+
+	result, err := db.Mongo().Collection("merchant_webhooks").DeleteOne(c.Request().Context(),
+		bson.M{"_id": webhookID, "merchantId": merchantID},
+	)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete webhook"})
+	}
+	if result.DeletedCount == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+}
+
+// rotateMerchantWebhookSecret genera un secreto nuevo; el anterior sigue firmando (como segundo v1)
+// durante WEBHOOK_SECRET_GRACE_HOURS para que el comercio alcance a actualizarlo
+func rotateMerchantWebhookSecret(c echo.Context) error {
+	merchantID, webhookID, ok := merchantWebhookIDs(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid merchant or webhook ID"})
+	}
+
+	ctx := c.Request().Context()
+	current, err := webhooks.Load(ctx, webhookID)
+	if err != nil || current.MerchantID != merchantID {
+		if err == nil || errors.Is(err, webhooks.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	}
+
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate secret"})
+	}
+	now := time.Now()
+	previousExpiresAt := now.Add(time.Duration(config.C.WebhookSecretGraceHours) * time.Hour)
+
+	// Condicional al secreto leído: dos rotaciones simultáneas no pierden el secreto anterior
+	var endpoint webhooks.Endpoint
+	err = db.Mongo().Collection("merchant_webhooks").FindOneAndUpdate(ctx,
+		bson.M{"_id": webhookID, "secret": current.Secret},
+		bson.M{"$set": bson.M{
+			"secret":            secret,
+			"previousSecret":    current.Secret,
+			"previousExpiresAt": previousExpiresAt,
+			"rotatedAt":         now,
+			"updatedAt":         now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&endpoint)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Secret was rotated concurrently"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rotate secret"})
+	}
+
+	return c.JSON(http.StatusOK, WebhookEndpointResponse{Secret: secret, Endpoint: &endpoint})
+}
+
+// pingMerchantWebhook envía un evento "ping" firmado de inmediato y devuelve lo que respondió el comercio
+func pingMerchantWebhook(c echo.Context) error {
+	merchantID, webhookID, ok := merchantWebhookIDs(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid merchant or webhook ID"})
+	}
+
+	ctx := c.Request().Context()
+	endpoint, err := webhooks.Load(ctx, webhookID)
+	if err != nil || endpoint.MerchantID != merchantID {
+		if err == nil || errors.Is(err, webhooks.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	}
+	// El ping se envía aunque el endpoint esté deshabilitado, para probarlo antes de activarlo
+	endpoint.Disabled = false
+
+	deliveryID := primitive.NewObjectID().Hex()
+	payload, err := webhooks.NewEvent(deliveryID, webhooks.EventPing, map[string]string{"webhook_id": endpoint.ID.Hex()})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build event"})
+	}
+
+	start := time.Now()
+	resp, sendErr := webhooks.Deliver(ctx, endpoint, deliveryID, webhooks.EventPing, payload)
+	result := map[string]interface{}{
+		"delivery_id": deliveryID,
+		"success":     sendErr == nil,
+		"latency_ms":  time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		result["status_code"], result["response_body"] = resp.StatusCode, resp.Body
+	}
+	if sendErr != nil {
+		result["error"] = sendErr.Error()
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func validateWebhookRequest(req *WebhookEndpointRequest) error {
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		return err
+	}
+	return webhooks.ValidateEvents(req.Events)
+}
+
+func merchantWebhookIDs(c echo.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	merchantID, err1 := primitive.ObjectIDFromHex(c.Param("id"))
+	webhookID, err2 := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	return merchantID, webhookID, err1 == nil && err2 == nil
+}
+
+func merchantWebhookError(c echo.Context, err error) error {
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+}
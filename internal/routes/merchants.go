@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -10,6 +11,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/notify"
 )
 
 // Merchant representa un comercio
@@ -19,6 +21,48 @@ type Merchant struct {
 	Name        string             `json:"name" bson:"name"`
 	Phone       string             `json:"phone" bson:"phone"`
 	Accounts    []string           `json:"accounts" bson:"accounts"`
+	// Locale es el idioma de los avisos (es, en); vacío usa NOTIFY_DEFAULT_LOCALE
+	Locale string `json:"locale,omitempty" bson:"locale,omitempty"`
+	// Notifications son los canales por los que se avisa el resultado de la revisión;
+	// sin canales se usa WhatsApp al teléfono del comercio
+	Notifications []notify.Destination `json:"notifications,omitempty" bson:"notifications,omitempty"`
+	// Templates reemplaza las plantillas por defecto, por evento ("transaction.approved") o evento e idioma ("transaction.approved.en")
+	Templates map[string]notify.Template `json:"templates,omitempty" bson:"templates,omitempty"`
+}
+
+// notificationDestinations devuelve los canales activos; sin configuración avisa por WhatsApp al teléfono
+func (m *Merchant) notificationDestinations() []notify.Destination {
+	if len(m.Notifications) == 0 {
+		if m.Phone == "" {
+			return nil
+		}
+		return []notify.Destination{{Channel: notify.ChannelWhatsapp, To: m.Phone}}
+	}
+	var active []notify.Destination
+	for _, d := range m.Notifications {
+		if !d.Disabled {
+			active = append(active, d)
+		}
+	}
+	return active
+}
+
+// validateNotificationSettings revisa idioma, canales y plantillas del comercio
+func validateNotificationSettings(m *Merchant) error {
+	if m.Locale != "" && !notify.SupportedLocale(m.Locale) {
+		return fmt.Errorf("unsupported locale: %s", m.Locale)
+	}
+	for _, d := range m.Notifications {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+	}
+	for event, t := range m.Templates {
+		if t.Body == "" {
+			return fmt.Errorf("template %s requires body", event)
+		}
+	}
+	return nil
 }
 
 // CreateMerchant crea un nuevo comercio
@@ -32,6 +76,9 @@ func CreateMerchant(c echo.Context) error {
 	if merchant.Responsible == "" || merchant.Name == "" || merchant.Phone == "" || merchant.Accounts == nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields: responsible, name, phone, accounts"})
 	}
+	if err := validateNotificationSettings(&merchant); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
 	// Insertar en MongoDB
 	newMerchant, err := db.Mongo().Collection("merchants").InsertOne(c.Request().Context(), merchant)
@@ -59,16 +106,22 @@ func UpdateMerchant(c echo.Context) error {
 	if merchant.Responsible == "" || merchant.Name == "" || merchant.Phone == "" || merchant.Accounts == nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields: responsible, name, phone, accounts"})
 	}
+	if err := validateNotificationSettings(&merchant); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
 	// Actualizar en MongoDB
 	result, err := db.Mongo().Collection("merchants").UpdateOne(
 		c.Request().Context(),
 		bson.M{"_id": merchantID},
 		bson.M{"$set": bson.M{
-			"responsible": merchant.Responsible,
-			"name":        merchant.Name,
-			"phone":       merchant.Phone,
-			"accounts":    merchant.Accounts,
+			"responsible":   merchant.Responsible,
+			"name":          merchant.Name,
+			"phone":         merchant.Phone,
+			"accounts":      merchant.Accounts,
+			"locale":        merchant.Locale,
+			"notifications": merchant.Notifications,
+			"templates":     merchant.Templates,
 		}},
 	)
 	if err != nil {
@@ -0,0 +1,493 @@
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/jwtkeys"
+	"github.com/usuario/valpago-backend/internal/totp"
+)
+
+const (
+	mfaIssuer          = "ValPago"
+	mfaChallengeTTL    = 5 * time.Minute
+	mfaChallengeMax    = 5 // intentos de código por challenge
+	mfaRecoveryCodes   = 10
+	tokenPurposeMFA    = "mfa"
+	mfaPolicySettingID = "mfa_policy"
+)
+
+var (
+	errInvalidMFACode  = errors.New("invalid code")
+	errMFANotEnrolling = errors.New("no pending MFA enrollment")
+)
+
+// MFAChallengeResponse es la respuesta de login cuando falta el segundo factor
+type MFAChallengeResponse struct {
+	MFARequired           bool   `json:"mfa_required"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string `json:"mfa_token"`
+	ExpiresIn             int64  `json:"expires_in"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"otpauth_uri"`
+}
+
+// MFAActivateResponse devuelve los códigos de recuperación en claro una única vez
+type MFAActivateResponse struct {
+	RecoveryCodes []string       `json:"recovery_codes"`
+	Login         *LoginResponse `json:"login,omitempty"`
+}
+
+// MFAPolicy indica qué roles deben tener 2FA activo para iniciar sesión
+type MFAPolicy struct {
+	ID            string    `json:"-" bson:"_id"`
+	RequiredRoles []string  `json:"requiredRoles" bson:"requiredRoles"`
+	UpdatedBy     string    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt     time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// signChallengeToken emite el token intermedio que devuelve login cuando falta el segundo factor.
+// Lleva purpose=mfa, por lo que parseToken lo rechaza como access token.
+func signChallengeToken(user User) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &JWTClaims{
+		UserID:  user.ID.Hex(),
+		Purpose: tokenPurposeMFA,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    config.C.JWTIssuer,
+			Subject:   user.ID.Hex(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return jwtkeys.Sign(claims)
+}
+
+// mfaChallenge devuelve la respuesta de login con el challenge de segundo factor
+func mfaChallenge(c echo.Context, user User, enrollment bool) error {
+	token, err := signChallengeToken(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create token"})
+	}
+	return c.JSON(http.StatusOK, MFAChallengeResponse{
+		MFARequired:           true,
+		MFAEnrollmentRequired: enrollment,
+		MFAToken:              token,
+		ExpiresIn:             int64(mfaChallengeTTL.Seconds()),
+	})
+}
+
+// userFromChallenge valida el challenge token, limita los intentos y carga el usuario
+func userFromChallenge(c echo.Context, tokenString string) (*User, *JWTClaims, error) {
+	claims, err := parseTokenWithPurpose(tokenString, tokenPurposeMFA)
+	if err != nil {
+		return nil, nil, unauthorized(c, "Invalid or expired MFA token")
+	}
+
+	ctx := c.Request().Context()
+	if db.Rdb != nil {
+		key := fmt.Sprintf("auth:mfa:challenge:%s", claims.ID)
+		n, _ := db.Rdb.Incr(ctx, key).Result()
+		db.Rdb.Expire(ctx, key, mfaChallengeTTL)
+		if n > mfaChallengeMax {
+			return nil, nil, unauthorized(c, "Too many attempts, log in again")
+		}
+	}
+
+	user, err := loadUser(ctx, claims.UserID)
+	if err != nil || !user.IsActive {
+		return nil, nil, unauthorized(c, "Invalid or expired MFA token")
+	}
+	return user, claims, nil
+}
+
+// consumeChallenge marca el challenge como usado para que no sirva dos veces
+func consumeChallenge(ctx context.Context, claims *JWTClaims) bool {
+	if db.Rdb == nil {
+		return true
+	}
+	ok, err := db.Rdb.SetNX(ctx, fmt.Sprintf("auth:mfa:used:%s", claims.ID), "1", mfaChallengeTTL).Result()
+	return err == nil && ok
+}
+
+// verifyMFALogin completa el login en dos pasos con un código TOTP o de recuperación
+func verifyMFALogin(c echo.Context) error {
+	var req MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	user, claims, err := userFromChallenge(c, req.MFAToken)
+	if user == nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "MFA is not enabled for this user"})
+	}
+
+	ctx := c.Request().Context()
+	if err := checkSecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+		recordLoginFailure(ctx, user.Email, c.RealIP())
+		auditLogin(c, user.Email, user.ID.Hex(), false, "bad_mfa_code")
+		return unauthorized(c, "Invalid code")
+	}
+	if !consumeChallenge(ctx, claims) {
+		return unauthorized(c, "MFA token already used")
+	}
+
+	resp, err := issueSession(c, *user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create token"})
+	}
+	auditLogin(c, user.Email, user.ID.Hex(), true, "mfa")
+	return c.JSON(http.StatusOK, resp)
+}
+
+// enrollMFAFromChallenge inicia el enrolamiento obligatorio durante el login
+func enrollMFAFromChallenge(c echo.Context) error {
+	var req MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	user, _, err := userFromChallenge(c, req.MFAToken)
+	if user == nil {
+		return err
+	}
+	return respondEnrollment(c, user)
+}
+
+// activateMFAFromChallenge confirma el enrolamiento obligatorio y completa el login
+func activateMFAFromChallenge(c echo.Context) error {
+	var req MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	user, claims, err := userFromChallenge(c, req.MFAToken)
+	if user == nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	codes, err := activateMFA(ctx, user, req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	if !consumeChallenge(ctx, claims) {
+		return unauthorized(c, "MFA token already used")
+	}
+
+	resp, err := issueSession(c, *user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create token"})
+	}
+	auditLogin(c, user.Email, user.ID.Hex(), true, "mfa_enrolled")
+	return c.JSON(http.StatusOK, MFAActivateResponse{RecoveryCodes: codes, Login: &resp})
+}
+
+// enrollMyMFA inicia el enrolamiento del usuario autenticado
+func enrollMyMFA(c echo.Context) error {
+	user, err := loadUser(c.Request().Context(), currentClaims(c).UserID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	return respondEnrollment(c, user)
+}
+
+// activateMyMFA confirma el enrolamiento del usuario autenticado
+func activateMyMFA(c echo.Context) error {
+	var req MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	ctx := c.Request().Context()
+	user, err := loadUser(ctx, currentClaims(c).UserID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	codes, err := activateMFA(ctx, user, req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, MFAActivateResponse{RecoveryCodes: codes})
+}
+
+// regenerateMyRecoveryCodes reemplaza los códigos de recuperación (requiere un código TOTP)
+func regenerateMyRecoveryCodes(c echo.Context) error {
+	var req MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	ctx := c.Request().Context()
+	user, err := loadUser(ctx, currentClaims(c).UserID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if !user.MFAEnabled {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "MFA is not enabled"})
+	}
+	if err := checkSecondFactor(ctx, user, req.Code, ""); err != nil {
+		return unauthorized(c, "Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate recovery codes"})
+	}
+	if _, err := db.Mongo().Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"mfaRecoveryCodes": hashes}}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+	return c.JSON(http.StatusOK, MFAActivateResponse{RecoveryCodes: codes})
+}
+
+// disableMyMFA desactiva 2FA (requiere código) salvo que el rol lo exija
+func disableMyMFA(c echo.Context) error {
+	var req MFAVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	ctx := c.Request().Context()
+	user, err := loadUser(ctx, currentClaims(c).UserID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if !user.MFAEnabled {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "MFA is not enabled"})
+	}
+	if mfaRequiredForRole(ctx, user.Role) {
+		return forbidden(c, "MFA is required for your role")
+	}
+	if err := checkSecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+		return unauthorized(c, "Invalid code")
+	}
+	if err := clearMFA(ctx, user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "MFA disabled"})
+}
+
+// resetUserMFA borra el 2FA de un usuario que perdió su dispositivo (admin) y cierra sus sesiones
+func resetUserMFA(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	ctx := c.Request().Context()
+	if err := clearMFA(ctx, id); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+	_, _ = revokeUserSessions(ctx, id.Hex())
+	return c.JSON(http.StatusOK, map[string]string{"message": "MFA reset successfully"})
+}
+
+func getMFAPolicy(c echo.Context) error {
+	return c.JSON(http.StatusOK, loadMFAPolicy(c.Request().Context()))
+}
+
+func updateMFAPolicy(c echo.Context) error {
+	var req MFAPolicy
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.RequiredRoles == nil {
+		req.RequiredRoles = []string{}
+	}
+	for _, r := range req.RequiredRoles {
+		if !validRole(r) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role: " + r})
+		}
+	}
+
+	policy := MFAPolicy{
+		ID:            mfaPolicySettingID,
+		RequiredRoles: req.RequiredRoles,
+		UpdatedBy:     currentClaims(c).UserID,
+		UpdatedAt:     time.Now(),
+	}
+	_, err := db.Mongo().Collection("settings").ReplaceOne(c.Request().Context(),
+		bson.M{"_id": mfaPolicySettingID}, policy, options.Replace().SetUpsert(true))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update MFA policy"})
+	}
+	return c.JSON(http.StatusOK, policy)
+}
+
+func loadMFAPolicy(ctx context.Context) MFAPolicy {
+	policy := MFAPolicy{ID: mfaPolicySettingID, RequiredRoles: []string{}}
+	_ = db.Mongo().Collection("settings").FindOne(ctx, bson.M{"_id": mfaPolicySettingID}).Decode(&policy)
+	return policy
+}
+
+func mfaRequiredForRole(ctx context.Context, role string) bool {
+	return contains(loadMFAPolicy(ctx).RequiredRoles, role)
+}
+
+func respondEnrollment(c echo.Context, user *User) error {
+	if user.MFAEnabled {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "MFA is already enabled"})
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate secret"})
+	}
+	if _, err := db.Mongo().Collection("users").UpdateOne(c.Request().Context(),
+		bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"mfaPendingSecret": secret}}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+	return c.JSON(http.StatusOK, MFAEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(mfaIssuer, user.Email, secret),
+	})
+}
+
+// activateMFA confirma el secreto pendiente con un código válido y genera los códigos de recuperación
+func activateMFA(ctx context.Context, user *User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, errors.New("MFA is already enabled")
+	}
+	if user.MFAPendingSecret == "" {
+		return nil, errMFANotEnrolling
+	}
+	counter, ok := totp.Validate(user.MFAPendingSecret, code, time.Now(), 1)
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Mongo().Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"mfaEnabled":       true,
+				"mfaSecret":        user.MFAPendingSecret,
+				"mfaRecoveryCodes": hashes,
+				"mfaLastCounter":   counter,
+			},
+			"$unset": bson.M{"mfaPendingSecret": ""},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkSecondFactor valida un código TOTP (rechazando reutilizaciones) o consume un código de recuperación
+func checkSecondFactor(ctx context.Context, user *User, code, recoveryCode string) error {
+	users := db.Mongo().Collection("users")
+
+	if recoveryCode != "" {
+		hash := sha256Hex(normalizeRecoveryCode(recoveryCode))
+		for _, h := range user.MFARecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+				result, err := users.UpdateOne(ctx, bson.M{"_id": user.ID, "mfaRecoveryCodes": h}, bson.M{"$pull": bson.M{"mfaRecoveryCodes": h}})
+				if err != nil {
+					return err
+				}
+				if result.ModifiedCount == 0 {
+					return errInvalidMFACode
+				}
+				return nil
+			}
+		}
+		return errInvalidMFACode
+	}
+
+	counter, ok := totp.Validate(user.MFASecret, code, time.Now(), 1)
+	if !ok {
+		return errInvalidMFACode
+	}
+	// Solo se acepta un código por paso de tiempo
+	result, err := users.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "mfaLastCounter": bson.M{"$lt": counter}},
+		bson.M{"$set": bson.M{"mfaLastCounter": counter}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return errInvalidMFACode
+	}
+	return nil
+}
+
+func clearMFA(ctx context.Context, id primitive.ObjectID) error {
+	_, err := db.Mongo().Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"mfaEnabled": false},
+			"$unset": bson.M{"mfaSecret": "", "mfaPendingSecret": "", "mfaRecoveryCodes": "", "mfaLastCounter": ""},
+		},
+	)
+	return err
+}
+
+// generateRecoveryCodes devuelve los códigos en claro (xxxxx-xxxxx) y sus hashes SHA-256
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, mfaRecoveryCodes)
+	hashes := make([]string, 0, mfaRecoveryCodes)
+	for i := 0; i < mfaRecoveryCodes; i++ {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(secret[:5] + "-" + secret[5:10])
+		codes = append(codes, code)
+		hashes = append(hashes, sha256Hex(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func mfaError(c echo.Context, err error) error {
+	switch err {
+	case errInvalidMFACode:
+		return unauthorized(c, "Invalid code")
+	case errMFANotEnrolling:
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Start MFA enrollment first"})
+	}
+	return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+}
+
+func loadUser(ctx context.Context, userID string) (*User, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	var user User
+	if err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,188 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleReviewer = "reviewer"
+	RoleUser     = "user"
+)
+
+// claimsContextKey es la clave con la que Authorize guarda los claims en el contexto
+const claimsContextKey = "claims"
+
+// routePolicy define quién puede invocar una ruta
+type routePolicy struct {
+	Public bool     // no requiere token (login, o rutas con su propia autenticación)
+	Roles  []string // vacío = cualquier usuario autenticado
+}
+
+// policies es la tabla de autorización por ruta, con clave "METHOD /path" tal como se registra en Echo.
+// Las rutas que no aparecen aquí solo son accesibles para administradores.
+var policies = map[string]routePolicy{
+	// Auth
+	"POST /api/auth/login":      {Public: true},
+	"POST /api/auth/refresh":    {Public: true},
+	"POST /api/auth/logout":     {},
+	"POST /api/auth/logout-all": {},
+	"POST /api/auth/forgot":     {Public: true},
+	"POST /api/auth/reset":      {Public: true},
+	// El challenge token de MFA viaja en el body
+	"POST /api/auth/mfa/verify":   {Public: true},
+	"POST /api/auth/mfa/enroll":   {Public: true},
+	"POST /api/auth/mfa/activate": {Public: true},
+
+	// Users
+	"POST /api/users":                {Roles: []string{RoleAdmin}},
+	"GET /api/users":                 {Roles: []string{RoleAdmin}},
+	"GET /api/users/:id":             {Roles: []string{RoleAdmin}},
+	"PUT /api/users/:id":             {Roles: []string{RoleAdmin}},
+	"DELETE /api/users/:id/sessions": {Roles: []string{RoleAdmin}},
+	"POST /api/users/:id/unlock":     {Roles: []string{RoleAdmin}},
+	"DELETE /api/users/:id/mfa":      {Roles: []string{RoleAdmin}},
+	// Self-service: cualquier usuario autenticado
+	"PUT /api/users/me/password":            {},
+	"POST /api/users/me/mfa/enroll":         {},
+	"POST /api/users/me/mfa/activate":       {},
+	"POST /api/users/me/mfa/recovery-codes": {},
+	"DELETE /api/users/me/mfa":              {},
+
+	// Transactions (create se autentica con API key)
+	"POST /api/transactions/create":     {Public: true},
+	"GET /api/transactions":             {Roles: []string{RoleReviewer, RoleAdmin}},
+	"GET /api/transactions/:id":         {Roles: []string{RoleReviewer, RoleAdmin}},
+	"GET /api/transactions/:id/receipt": {Roles: []string{RoleReviewer, RoleAdmin}},
+	"PUT /api/transactions/:id/status":  {Roles: []string{RoleAdmin}},
+	"PUT /api/transactions/:id/draft":   {Roles: []string{RoleReviewer, RoleAdmin}},
+	"PUT /api/transactions/:id/submit":  {Roles: []string{RoleReviewer, RoleAdmin}},
+	"PUT /api/transactions/:id/review":  {Roles: []string{RoleReviewer, RoleAdmin}},
+	"PUT /api/transactions/:id/approve": {Roles: []string{RoleReviewer, RoleAdmin}},
+	"PUT /api/transactions/:id/reject":  {Roles: []string{RoleReviewer, RoleAdmin}},
+
+	// Merchants
+	"POST /api/merchants":                                       {Roles: []string{RoleAdmin}},
+	"GET /api/merchants":                                        {Roles: []string{RoleReviewer, RoleAdmin}},
+	"GET /api/merchants/:id":                                    {Roles: []string{RoleReviewer, RoleAdmin}},
+	"PUT /api/merchants/:id":                                    {Roles: []string{RoleAdmin}},
+	"DELETE /api/merchants/:id":                                 {Roles: []string{RoleAdmin}},
+	"POST /api/merchants/:id/webhooks":                          {Roles: []string{RoleAdmin}},
+	"GET /api/merchants/:id/webhooks":                           {Roles: []string{RoleAdmin}},
+	"PUT /api/merchants/:id/webhooks/:webhookId":                {Roles: []string{RoleAdmin}},
+	"DELETE /api/merchants/:id/webhooks/:webhookId":             {Roles: []string{RoleAdmin}},
+	"POST /api/merchants/:id/webhooks/:webhookId/rotate-secret": {Roles: []string{RoleAdmin}},
+	"POST /api/merchants/:id/webhooks/:webhookId/ping":          {Roles: []string{RoleAdmin}},
+
+	// Merchant notifications
+	"GET /api/notifications":             {Roles: []string{RoleReviewer, RoleAdmin}},
+	"POST /api/notifications/:id/resend": {Roles: []string{RoleAdmin}},
+
+	// API keys
+	"POST /api/apikeys":            {Roles: []string{RoleAdmin}},
+	"GET /api/apikeys":             {Roles: []string{RoleAdmin}},
+	"POST /api/apikeys/:id/rotate": {Roles: []string{RoleAdmin}},
+	"DELETE /api/apikeys/:id":      {Roles: []string{RoleAdmin}},
+
+	// Admin settings
+	"GET /api/admin/mfa-policy": {Roles: []string{RoleAdmin}},
+	"PUT /api/admin/mfa-policy": {Roles: []string{RoleAdmin}},
+
+	// Worker dead-letter stream
+	"GET /api/admin/dlq":             {Roles: []string{RoleAdmin}},
+	"GET /api/admin/dlq/:id":         {Roles: []string{RoleAdmin}},
+	"POST /api/admin/dlq/:id/replay": {Roles: []string{RoleAdmin}},
+	"DELETE /api/admin/dlq/:id":      {Roles: []string{RoleAdmin}},
+
+	// Redis stream metrics
+	"GET /api/admin/streams": {Roles: []string{RoleAdmin}},
+
+	// Realtime
+	"GET /api/sse": {Roles: []string{RoleReviewer, RoleAdmin}},
+}
+
+var defaultPolicy = routePolicy{Roles: []string{RoleAdmin}}
+
+// Authorize valida el JWT de la petición contra las llaves de jwtkeys (algoritmo según JWT_ALG),
+// guarda los claims en el contexto y aplica la política de la ruta.
+func Authorize(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Path()
+		policy, ok := policies[c.Request().Method+" "+path]
+		if !ok {
+			// Catch-all del grupo: dejar que Echo responda 404
+			if strings.HasSuffix(path, "/*") {
+				return next(c)
+			}
+			policy = defaultPolicy
+		}
+		if policy.Public {
+			return next(c)
+		}
+
+		tokenString := bearerToken(c)
+		if tokenString == "" {
+			return unauthorized(c, "Authentication required")
+		}
+		claims, err := parseToken(tokenString)
+		if err != nil {
+			return unauthorized(c, "Invalid or expired token")
+		}
+		revoked, err := isTokenRevoked(c.Request().Context(), claims)
+		if err != nil {
+			log.Printf("Revocation check failed: %v", err)
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Unable to verify session"})
+		}
+		if revoked {
+			return unauthorized(c, "Token has been revoked")
+		}
+		c.Set(claimsContextKey, claims)
+
+		if len(policy.Roles) > 0 && !contains(policy.Roles, claims.Role) {
+			return forbidden(c, "Insufficient permissions")
+		}
+		return next(c)
+	}
+}
+
+// currentClaims devuelve los claims del usuario autenticado, o nil en rutas públicas
+func currentClaims(c echo.Context) *JWTClaims {
+	claims, _ := c.Get(claimsContextKey).(*JWTClaims)
+	return claims
+}
+
+// bearerToken extrae el token del header Authorization. EventSource no permite
+// enviar headers, así que también se acepta ?access_token= (usado por /api/sse).
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return c.QueryParam("access_token")
+}
+
+func validRole(role string) bool {
+	return contains([]string{RoleAdmin, RoleReviewer, RoleUser}, role)
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func unauthorized(c echo.Context, msg string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="valpago"`)
+	return c.JSON(http.StatusUnauthorized, map[string]string{"error": msg})
+}
+
+func forbidden(c echo.Context, msg string) error {
+	return c.JSON(http.StatusForbidden, map[string]string{"error": msg})
+}
@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/notify"
+	"github.com/usuario/valpago-backend/internal/outbox"
+)
+
+const (
+	defaultNotificationsLimit = 50
+	maxNotificationsLimit     = 200
+	// pendingNotificationGrace es cuánto se espera antes de dar por perdido el encolado de un cambio de estado
+	pendingNotificationGrace = time.Minute
+	maxPendingNotifications  = 100
+)
+
+// enqueueMerchantNotifications encola en el outbox el aviso del cambio de estado para cada canal del comercio
+// dueño de la cuenta destino (con sus plantillas e idioma) y el evento para sus webhooks firmados.
+// Quita la marca notification_pending al terminar; si falla, la marca queda y EnqueuePendingNotifications lo reintenta.
+func enqueueMerchantNotifications(ctx context.Context, tx *Transaction) {
+	if err := queueMerchantNotifications(ctx, tx); err != nil {
+		log.Printf("Failed to enqueue notifications for transaction %s: %v", tx.ID.Hex(), err)
+		return
+	}
+	if _, err := db.Mongo().Collection("transactions").UpdateOne(ctx,
+		bson.M{"_id": tx.ID},
+		bson.M{"$unset": bson.M{"notification_pending": ""}},
+	); err != nil {
+		log.Printf("Failed to clear notification_pending for transaction %s: %v", tx.ID.Hex(), err)
+	}
+}
+
+func queueMerchantNotifications(ctx context.Context, tx *Transaction) error {
+	var merchant Merchant
+	err := db.Mongo().Collection("merchants").FindOne(
+		ctx,
+		bson.M{"accounts": tx.DestinationAccount},
+	).Decode(&merchant)
+	if err != nil {
+		log.Printf("No merchant found for account: %s", tx.DestinationAccount)
+		return nil
+	}
+
+	event := "transaction." + tx.Status
+	vars := notify.Vars{
+		Amount:        tx.Amount,
+		Reference:     tx.Reference,
+		Merchant:      merchant.Name,
+		TransactionID: tx.ID.Hex(),
+		Date:          tx.Date,
+	}
+	if n := len(tx.StatusHistory); n > 0 {
+		vars.Reason = tx.StatusHistory[n-1].Reason
+	}
+
+	var intents []outbox.Intent
+	for _, d := range merchant.notificationDestinations() {
+		locale := d.Locale
+		if locale == "" {
+			locale = merchant.Locale
+		}
+		msg, err := notify.Render(event, locale, merchant.Templates, vars)
+		if err != nil {
+			log.Printf("Skipping %s notification for transaction %s: %v", d.Channel, tx.ID.Hex(), err)
+			continue
+		}
+		intents = append(intents, outbox.NewIntent(tx.ID, merchant.ID.Hex(), event, d, msg))
+	}
+
+	// Endpoints firmados que registró el comercio para el evento
+	hooks, err := webhookIntents(ctx, &merchant, tx, event, vars.Reason)
+	if err != nil {
+		return err
+	}
+	intents = append(intents, hooks...)
+
+	if len(intents) == 0 {
+		log.Printf("Merchant %s has no notification channels", merchant.ID.Hex())
+		return nil
+	}
+	return outbox.Enqueue(ctx, intents...)
+}
+
+// EnqueuePendingNotifications encola los avisos de transacciones que cambiaron de estado pero no
+// alcanzaron a encolarlos. Lo llama el dispatcher en cada vuelta.
+func EnqueuePendingNotifications(ctx context.Context) {
+	cursor, err := db.Mongo().Collection("transactions").Find(ctx,
+		bson.M{"notification_pending": true, "updatedAt": bson.M{"$lte": time.Now().Add(-pendingNotificationGrace)}},
+		options.Find().SetLimit(maxPendingNotifications),
+	)
+	if err != nil {
+		log.Printf("Failed to look up pending notifications: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tx Transaction
+		if err := cursor.Decode(&tx); err != nil {
+			continue
+		}
+		enqueueMerchantNotifications(ctx, &tx)
+	}
+}
+
+// listNotifications lista el outbox, del más reciente al más antiguo, con paginación por cursor.
+// Filtros: status, transaction, merchant, event y channel (status y event aceptan varios valores).
+func listNotifications(c echo.Context) error {
+	params := c.QueryParams()
+	filter := bson.M{}
+
+	if statuses := splitMulti(params["status"]); len(statuses) > 0 {
+		for _, s := range statuses {
+			if !contains(outbox.Statuses, s) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid status: %s", s)})
+			}
+		}
+		filter["status"] = bson.M{"$in": statuses}
+	}
+	if v := c.QueryParam("transaction"); v != "" {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
+		}
+		filter["transactionId"] = id
+	}
+	if v := c.QueryParam("merchant"); v != "" {
+		filter["merchantId"] = v
+	}
+	if events := splitMulti(params["event"]); len(events) > 0 {
+		filter["event"] = bson.M{"$in": events}
+	}
+	if v := c.QueryParam("channel"); v != "" {
+		filter["destination.channel"] = v
+	}
+
+	limit := int64(defaultNotificationsLimit)
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 || n > maxNotificationsLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("limit must be between 1 and %d", maxNotificationsLimit)})
+		}
+		limit = n
+	}
+
+	pageFilter := filter
+	if v := c.QueryParam("cursor"); v != "" {
+		cur, err := decodeCursor(v)
+		if err != nil || cur.Field != "createdAt" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+		}
+		id, err := primitive.ObjectIDFromHex(cur.ID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+		}
+		pageFilter = bson.M{"$and": bson.A{filter, bson.M{"$or": bson.A{
+			bson.M{"createdAt": bson.M{"$lt": cur.Time}},
+			bson.M{"createdAt": cur.Time, "_id": bson.M{"$lt": id}},
+		}}}}
+	}
+
+	ctx := c.Request().Context()
+	collection := db.Mongo().Collection("notification_outbox")
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit + 1)
+	cursor, err := collection.Find(ctx, pageFilter, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch notifications"})
+	}
+	defer cursor.Close(ctx)
+
+	notifications := []outbox.Intent{}
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode notifications"})
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to count notifications"})
+	}
+
+	hasMore := int64(len(notifications)) > limit
+	nextCursor := ""
+	if hasMore {
+		notifications = notifications[:limit]
+		last := notifications[len(notifications)-1]
+		raw, _ := json.Marshal(pageCursor{Field: "createdAt", Desc: true, ID: last.ID.Hex(), Time: last.CreatedAt})
+		nextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"notifications": notifications,
+		"pagination": map[string]interface{}{
+			"limit":       limit,
+			"total":       total,
+			"has_more":    hasMore,
+			"next_cursor": nextCursor,
+		},
+	})
+}
+
+// resendNotification vuelve a encolar un aviso entregado o en dead para que el dispatcher lo reenvíe
+func resendNotification(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid notification ID"})
+	}
+
+	intent, err := outbox.Resend(c.Request().Context(), id)
+	switch {
+	case errors.Is(err, outbox.ErrNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Notification not found"})
+	case errors.Is(err, outbox.ErrAlreadyPending):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Notification is already pending delivery"})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to resend notification"})
+	}
+
+	return c.JSON(http.StatusAccepted, intent)
+}
@@ -0,0 +1,263 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/notify"
+	"github.com/usuario/valpago-backend/internal/password"
+)
+
+const (
+	resetCodeDigits      = 6
+	resetMaxAttempts     = 5
+	resetRequestCooldown = time.Minute
+	// Códigos fallidos por usuario en resetFailureWindow; al superarlos no se emiten ni aceptan códigos
+	resetMaxFailures   = 20
+	resetFailureWindow = 24 * time.Hour
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ForgotPasswordRequest struct {
+	Email   string `json:"email"`
+	Channel string `json:"channel"` // whatsapp | email
+}
+
+type ResetPasswordRequest struct {
+	Email       string `json:"email"`
+	Code        string `json:"code"`
+	NewPassword string `json:"new_password"`
+}
+
+// changeMyPassword cambia la contraseña del usuario autenticado y cierra sus demás sesiones
+func changeMyPassword(c echo.Context) error {
+	var req ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	claims := currentClaims(c)
+	ctx := c.Request().Context()
+	id, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+
+	var user User
+	if err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch user"})
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)) != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Current password is incorrect"})
+	}
+	if err := password.Validate(req.NewPassword); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := setUserPassword(ctx, id, req.NewPassword); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update password"})
+	}
+	if _, err := revokeOtherSessions(ctx, claims.UserID, claims.SessionID); err != nil {
+		log.Printf("Failed to revoke sessions after password change for %s: %v", claims.UserID, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password updated successfully"})
+}
+
+// forgotPassword emite un código de un solo uso y lo entrega por WhatsApp o email.
+// Responde siempre lo mismo para no revelar qué emails están registrados.
+func forgotPassword(c echo.Context) error {
+	var req ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil || req.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.Channel == "" {
+		req.Channel = notify.ChannelWhatsapp
+	}
+	if req.Channel != notify.ChannelWhatsapp && req.Channel != notify.ChannelEmail {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid channel"})
+	}
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+
+	accepted := map[string]string{"message": "If the account exists, a reset code has been sent"}
+
+	ctx := c.Request().Context()
+	var user User
+	if err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"email": req.Email}).Decode(&user); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("forgotPassword lookup failed: %v", err)
+		}
+		return c.JSON(http.StatusAccepted, accepted)
+	}
+	if !user.IsActive {
+		return c.JSON(http.StatusAccepted, accepted)
+	}
+
+	uid := user.ID.Hex()
+	if resetLocked(ctx, uid) {
+		log.Printf("Password reset locked for user %s after too many failed codes", uid)
+		return c.JSON(http.StatusAccepted, accepted)
+	}
+	if ok, _ := db.Rdb.SetNX(ctx, resetCooldownKey(uid), "1", resetRequestCooldown).Result(); !ok {
+		return c.JSON(http.StatusAccepted, accepted)
+	}
+
+	code, err := randomDigits(resetCodeDigits)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate code"})
+	}
+	// Los intentos no se reinician con el código nuevo: vencen solos (PASSWORD_RESET_TTL_MINUTES)
+	ttl := time.Duration(config.C.PasswordResetTTLMinutes) * time.Minute
+	if err := db.Rdb.Set(ctx, resetCodeKey(uid), sha256Hex(code), ttl).Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store code"})
+	}
+
+	to := user.Phone
+	if req.Channel == notify.ChannelEmail {
+		to = user.Email
+	}
+	msg := notify.Message{
+		Subject: "ValPago - Código de recuperación",
+		Body:    fmt.Sprintf("Tu código para restablecer la contraseña de ValPago es %s. Vence en %d minutos.", code, config.C.PasswordResetTTLMinutes),
+	}
+	if err := sendNotification(ctx, req.Channel, to, msg); err != nil {
+		log.Printf("Failed to deliver reset code to user %s via %s: %v", uid, req.Channel, err)
+	}
+
+	return c.JSON(http.StatusAccepted, accepted)
+}
+
+// resetPassword valida el código de un solo uso y fija la nueva contraseña
+func resetPassword(c echo.Context) error {
+	var req ResetPasswordRequest
+	if err := c.Bind(&req); err != nil || req.Email == "" || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if db.Rdb == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
+	}
+
+	invalid := map[string]string{"error": "Invalid or expired code"}
+
+	ctx := c.Request().Context()
+	var user User
+	if err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"email": req.Email}).Decode(&user); err != nil {
+		return c.JSON(http.StatusBadRequest, invalid)
+	}
+	uid := user.ID.Hex()
+	if resetLocked(ctx, uid) {
+		return c.JSON(http.StatusBadRequest, invalid)
+	}
+
+	stored, err := db.Rdb.Get(ctx, resetCodeKey(uid)).Result()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, invalid)
+	}
+
+	attempts, _ := db.Rdb.Incr(ctx, resetAttemptsKey(uid)).Result()
+	db.Rdb.Expire(ctx, resetAttemptsKey(uid), time.Duration(config.C.PasswordResetTTLMinutes)*time.Minute)
+	if attempts > resetMaxAttempts {
+		db.Rdb.Del(ctx, resetCodeKey(uid))
+		return c.JSON(http.StatusBadRequest, invalid)
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(sha256Hex(req.Code))) != 1 {
+		recordResetFailure(ctx, uid)
+		return c.JSON(http.StatusBadRequest, invalid)
+	}
+
+	if err := password.Validate(req.NewPassword); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := setUserPassword(ctx, user.ID, req.NewPassword); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update password"})
+	}
+	db.Rdb.Del(ctx, resetCodeKey(uid), resetAttemptsKey(uid), resetFailuresKey(uid))
+	clearLoginFailures(ctx, user.Email)
+	if _, err := revokeUserSessions(ctx, uid); err != nil {
+		log.Printf("Failed to revoke sessions after password reset for %s: %v", uid, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password reset successfully"})
+}
+
+// resetLocked indica que el usuario superó resetMaxFailures códigos fallidos en resetFailureWindow
+func resetLocked(ctx context.Context, uid string) bool {
+	n, err := db.Rdb.Get(ctx, resetFailuresKey(uid)).Int()
+	return err == nil && n >= resetMaxFailures
+}
+
+// recordResetFailure cuenta un código fallido; la ventana corre desde el primer fallo
+func recordResetFailure(ctx context.Context, uid string) {
+	n, err := db.Rdb.Incr(ctx, resetFailuresKey(uid)).Result()
+	if err != nil {
+		log.Printf("Failed to record reset failure for %s: %v", uid, err)
+		return
+	}
+	if n == 1 {
+		db.Rdb.Expire(ctx, resetFailuresKey(uid), resetFailureWindow)
+	}
+}
+
+func setUserPassword(ctx context.Context, id primitive.ObjectID, pw string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.Mongo().Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"password": string(hashed), "passwordChangedAt": time.Now()}},
+	)
+	return err
+}
+
+// sendNotification entrega un mensaje por el canal indicado usando el notifier configurado
+func sendNotification(ctx context.Context, channel, to string, msg notify.Message) error {
+	n, err := notify.New(channel)
+	if err != nil {
+		return err
+	}
+	_, err = n.Send(ctx, to, msg)
+	return err
+}
+
+func randomDigits(n int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < n; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
+
+func resetCodeKey(uid string) string     { return fmt.Sprintf("auth:reset:%s", uid) }
+func resetAttemptsKey(uid string) string { return fmt.Sprintf("auth:reset:%s:attempts", uid) }
+func resetCooldownKey(uid string) string { return fmt.Sprintf("auth:reset:%s:cooldown", uid) }
+func resetFailuresKey(uid string) string { return fmt.Sprintf("auth:reset:%s:failures", uid) }
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/storage"
+)
+
+func receiptURLTTL() time.Duration {
+	return time.Duration(config.C.StorageURLTTLMinutes) * time.Minute
+}
+
+// signReceiptURL completa ReceiptURL con una URL firmada si la transacción tiene comprobante guardado
+func signReceiptURL(ctx context.Context, tx *Transaction) {
+	s := storage.Default()
+	if tx.ReceiptKey == "" || s == nil {
+		return
+	}
+	url, err := s.SignedURL(ctx, tx.ReceiptKey, receiptURLTTL())
+	if err != nil {
+		log.Printf("Failed to sign receipt URL for transaction %s: %v", tx.ID.Hex(), err)
+		return
+	}
+	tx.ReceiptURL = url
+}
+
+// getTransactionReceipt devuelve una URL firmada y temporal para descargar el comprobante
+func getTransactionReceipt(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
+	}
+
+	ctx := c.Request().Context()
+	var tx Transaction
+	if err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"_id": id}).Decode(&tx); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Transaction not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	}
+	if tx.ReceiptKey == "" {
+		// El comprobante no se pudo descargar (o aún no): se informa explícitamente el estado
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error":         "Receipt not available",
+			"receipt_fetch": tx.ReceiptFetch,
+		})
+	}
+
+	s := storage.Default()
+	if s == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Storage not configured"})
+	}
+	ttl := receiptURLTTL()
+	url, err := s.SignedURL(ctx, tx.ReceiptKey, ttl)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Receipt not available"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to sign receipt URL"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"url":        url,
+		"expires_at": time.Now().Add(ttl),
+	})
+}
+
+// serveLocalFile sirve los archivos del backend local; la URL debe venir firmada por storage.Local.SignedURL
+func serveLocalFile(c echo.Context) error {
+	local, ok := storage.Default().(*storage.Local)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+
+	f, contentType, err := local.Open(c.Param("*"), c.QueryParam("expires"), c.QueryParam("signature"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Not found"})
+		}
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Invalid or expired URL"})
+	}
+	defer f.Close()
+
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	return c.Stream(http.StatusOK, contentType, f)
+}
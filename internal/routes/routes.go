@@ -5,22 +5,60 @@ import (
 )
 
 func Register(e *echo.Echo) {
+	// Public signing keys (JWKS)
+	e.GET("/.well-known/jwks.json", jwks)
+	// Archivos del storage local; la autorización va en la firma de la URL
+	e.GET("/files/*", serveLocalFile)
+	// WhatsApp Cloud API: verificación y mensajes entrantes (autenticados por verify token / firma de Meta)
+	e.GET("/webhooks/whatsapp", verifyWhatsappWebhook)
+	e.POST("/webhooks/whatsapp", receiveWhatsappWebhook)
+
 	// API routes
 	api := e.Group("/api")
+	api.Use(Authorize)
 
 	// Users routes
 	api.POST("/users", createUser)
 	api.GET("/users", listUsers)
 	api.GET("/users/:id", getUserByID)
+	api.PUT("/users/me/password", changeMyPassword)
+	api.POST("/users/me/mfa/enroll", enrollMyMFA)
+	api.POST("/users/me/mfa/activate", activateMyMFA)
+	api.POST("/users/me/mfa/recovery-codes", regenerateMyRecoveryCodes)
+	api.DELETE("/users/me/mfa", disableMyMFA)
+	api.DELETE("/users/:id/mfa", resetUserMFA)
 	api.PUT("/users/:id", updateUser)
+	api.DELETE("/users/:id/sessions", revokeUserSessionsByAdmin)
+	api.POST("/users/:id/unlock", unlockUser)
 
 	// Auth routes
 	api.POST("/auth/login", login)
+	api.POST("/auth/refresh", refreshSession)
+	api.POST("/auth/logout", logout)
+	api.POST("/auth/logout-all", logoutAll)
+	api.POST("/auth/forgot", forgotPassword)
+	api.POST("/auth/reset", resetPassword)
+	api.POST("/auth/mfa/verify", verifyMFALogin)
+	api.POST("/auth/mfa/enroll", enrollMFAFromChallenge)
+	api.POST("/auth/mfa/activate", activateMFAFromChallenge)
+
+	// Admin settings routes
+	api.GET("/admin/mfa-policy", getMFAPolicy)
+	api.PUT("/admin/mfa-policy", updateMFAPolicy)
+	api.GET("/admin/dlq", listDLQ)
+	api.GET("/admin/dlq/:id", getDLQEntry)
+	api.POST("/admin/dlq/:id/replay", replayDLQEntry)
+	api.DELETE("/admin/dlq/:id", deleteDLQEntry)
+	api.GET("/admin/streams", streamMetrics)
 
 	// Transactions routes
-	api.POST("/transactions/create", createTransaction)
+	api.POST("/transactions/create", createTransaction, requireAPIKey(ScopeTransactionsCreate), requireIdempotency)
 	api.GET("/transactions", listTransactions)
+	api.GET("/transactions/:id", getTransaction)
+	api.GET("/transactions/:id/receipt", getTransactionReceipt)
 	api.PUT("/transactions/:id/status", updateTransactionStatus)
+	api.PUT("/transactions/:id/draft", updateDraft)
+	api.PUT("/transactions/:id/submit", submitDraft)
 	api.PUT("/transactions/:id/review", reviewTransaction)
 	api.PUT("/transactions/:id/approve", approveTransaction)
 	api.PUT("/transactions/:id/reject", rejectTransaction)
@@ -31,4 +69,20 @@ func Register(e *echo.Echo) {
 	api.GET("/merchants/:id", GetMerchant)
 	api.PUT("/merchants/:id", UpdateMerchant)
 	api.DELETE("/merchants/:id", DeleteMerchant)
+	api.POST("/merchants/:id/webhooks", createMerchantWebhook)
+	api.GET("/merchants/:id/webhooks", listMerchantWebhooks)
+	api.PUT("/merchants/:id/webhooks/:webhookId", updateMerchantWebhook)
+	api.DELETE("/merchants/:id/webhooks/:webhookId", deleteMerchantWebhook)
+	api.POST("/merchants/:id/webhooks/:webhookId/rotate-secret", rotateMerchantWebhookSecret)
+	api.POST("/merchants/:id/webhooks/:webhookId/ping", pingMerchantWebhook)
+
+	// Merchant notifications (outbox)
+	api.GET("/notifications", listNotifications)
+	api.POST("/notifications/:id/resend", resendNotification)
+
+	// API keys routes
+	api.POST("/apikeys", createAPIKey)
+	api.GET("/apikeys", listAPIKeys)
+	api.POST("/apikeys/:id/rotate", rotateAPIKey)
+	api.DELETE("/apikeys/:id", revokeAPIKey)
 }
@@ -0,0 +1,325 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// Session es una sesión de login. El refresh token rota en cada uso; solo se guarda su hash.
+type Session struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID         string             `json:"userId" bson:"userId"`
+	RefreshHash    string             `json:"-" bson:"refreshHash"`
+	PreviousHashes []string           `json:"-" bson:"previousHashes,omitempty"`
+	UserAgent      string             `json:"userAgent" bson:"userAgent"`
+	IP             string             `json:"ip" bson:"ip"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	LastUsedAt     time.Time          `json:"lastUsedAt" bson:"lastUsedAt"`
+	ExpiresAt      time.Time          `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt      *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newRefreshToken genera un refresh token con formato <sessionID>.<secreto> y su hash
+func newRefreshToken(sid primitive.ObjectID) (string, string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	token := sid.Hex() + "." + secret
+	return token, sha256Hex(token), nil
+}
+
+// issueSession crea una sesión nueva para el usuario y devuelve access + refresh token
+func issueSession(c echo.Context, user User) (LoginResponse, error) {
+	now := time.Now()
+	session := Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID.Hex(),
+		UserAgent:  c.Request().UserAgent(),
+		IP:         c.RealIP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(time.Duration(config.C.RefreshTokenTTLHours) * time.Hour),
+	}
+	refreshToken, hash, err := newRefreshToken(session.ID)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	session.RefreshHash = hash
+
+	if _, err := db.Mongo().Collection("sessions").InsertOne(c.Request().Context(), session); err != nil {
+		return LoginResponse{}, err
+	}
+
+	accessToken, err := signAccessToken(user, session.ID.Hex())
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	user.Password = "" // Don't return password
+	return LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL().Seconds()),
+		User:         user,
+	}, nil
+}
+
+// refreshSession rota el refresh token y emite un access token nuevo.
+// Si se presenta un refresh token ya rotado se asume robo y se revoca la sesión.
+func refreshSession(c echo.Context) error {
+	var req RefreshRequest
+	if err := c.Bind(&req); err != nil || req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token is required"})
+	}
+
+	sidHex, _, ok := strings.Cut(req.RefreshToken, ".")
+	sid, err := primitive.ObjectIDFromHex(sidHex)
+	if !ok || err != nil {
+		return unauthorized(c, "Invalid refresh token")
+	}
+
+	ctx := c.Request().Context()
+	var session Session
+	if err := db.Mongo().Collection("sessions").FindOne(ctx, bson.M{"_id": sid}).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return unauthorized(c, "Invalid refresh token")
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	}
+
+	hash := sha256Hex(req.RefreshToken)
+	if subtle.ConstantTimeCompare([]byte(session.RefreshHash), []byte(hash)) != 1 {
+		if contains(session.PreviousHashes, hash) {
+			log.Printf("Refresh token reuse detected for session %s (user %s)", session.ID.Hex(), session.UserID)
+			_ = revokeSession(ctx, session.ID)
+		}
+		return unauthorized(c, "Invalid refresh token")
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return unauthorized(c, "Session expired")
+	}
+
+	// Recargar el usuario para reflejar cambios de rol o desactivación
+	userID, _ := primitive.ObjectIDFromHex(session.UserID)
+	var user User
+	if err := db.Mongo().Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return unauthorized(c, "Invalid refresh token")
+	}
+	if !user.IsActive {
+		_ = revokeSession(ctx, session.ID)
+		return unauthorized(c, "User is inactive")
+	}
+
+	refreshToken, newHash, err := newRefreshToken(session.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create token"})
+	}
+	// El filtro por refreshHash evita que dos refresh concurrentes con el mismo token ganen ambos
+	result, err := db.Mongo().Collection("sessions").UpdateOne(ctx,
+		bson.M{"_id": session.ID, "refreshHash": session.RefreshHash},
+		bson.M{
+			"$set":  bson.M{"refreshHash": newHash, "lastUsedAt": time.Now()},
+			"$push": bson.M{"previousHashes": bson.M{"$each": []string{session.RefreshHash}, "$slice": -20}},
+		},
+	)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to refresh session"})
+	}
+	if result.ModifiedCount == 0 {
+		return unauthorized(c, "Invalid refresh token")
+	}
+
+	accessToken, err := signAccessToken(user, session.ID.Hex())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create token"})
+	}
+
+	user.Password = "" // Don't return password
+	return c.JSON(http.StatusOK, LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL().Seconds()),
+		User:         user,
+	})
+}
+
+// logout revoca la sesión actual y pone el access token en la denylist
+func logout(c echo.Context) error {
+	claims := currentClaims(c)
+	ctx := c.Request().Context()
+
+	if claims.ID != "" && db.Rdb != nil {
+		_ = db.Rdb.Set(ctx, revokedJTIKey(claims.ID), "1", remainingTTL(claims)).Err()
+	}
+	if sid, err := primitive.ObjectIDFromHex(claims.SessionID); err == nil {
+		if err := revokeSession(ctx, sid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke session"})
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out"})
+}
+
+// logoutAll cierra todas las sesiones del usuario autenticado
+func logoutAll(c echo.Context) error {
+	claims := currentClaims(c)
+	n, err := revokeUserSessions(c.Request().Context(), claims.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke sessions"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"message": "All sessions revoked", "revoked": n})
+}
+
+// revokeUserSessionsByAdmin cierra todas las sesiones de un usuario (admin)
+func revokeUserSessionsByAdmin(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+	}
+	n, err := revokeUserSessions(c.Request().Context(), id.Hex())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke sessions"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"message": "All sessions revoked", "revoked": n})
+}
+
+// revokeSession marca la sesión como revocada y agrega su sid a la denylist de Redis,
+// invalidando de inmediato los access tokens emitidos para ella
+func revokeSession(ctx context.Context, sid primitive.ObjectID) error {
+	_, err := db.Mongo().Collection("sessions").UpdateOne(ctx,
+		bson.M{"_id": sid, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	denySession(ctx, sid.Hex())
+	return nil
+}
+
+// revokeUserSessions revoca todas las sesiones activas de un usuario y devuelve cuántas fueron
+func revokeUserSessions(ctx context.Context, userID string) (int, error) {
+	return revokeSessions(ctx, bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}})
+}
+
+// revokeOtherSessions revoca todas las sesiones del usuario excepto keepSID (la actual)
+func revokeOtherSessions(ctx context.Context, userID, keepSID string) (int, error) {
+	filter := bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}}
+	if keep, err := primitive.ObjectIDFromHex(keepSID); err == nil {
+		filter["_id"] = bson.M{"$ne": keep}
+	}
+	return revokeSessions(ctx, filter)
+}
+
+func revokeSessions(ctx context.Context, filter bson.M) (int, error) {
+	cursor, err := db.Mongo().Collection("sessions").Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var sessions []Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return 0, err
+	}
+
+	if _, err := db.Mongo().Collection("sessions").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}}); err != nil {
+		return 0, err
+	}
+	for _, s := range sessions {
+		denySession(ctx, s.ID.Hex())
+	}
+	return len(sessions), nil
+}
+
+func denySession(ctx context.Context, sid string) {
+	if db.Rdb == nil {
+		return
+	}
+	// Basta con que la entrada viva lo mismo que un access token
+	if err := db.Rdb.Set(ctx, revokedSessionKey(sid), "1", accessTokenTTL()).Err(); err != nil {
+		log.Printf("Failed to deny session %s: %v", sid, err)
+	}
+}
+
+// isTokenRevoked consulta la denylist (jti y sid) en Redis; sin Redis revisa la sesión en MongoDB.
+// Si la consulta falla devuelve el error para rechazar la petición (no se puede saber si se revocó).
+func isTokenRevoked(ctx context.Context, claims *JWTClaims) (bool, error) {
+	if db.Rdb == nil {
+		return isSessionRevoked(ctx, claims.SessionID)
+	}
+	keys := []string{revokedJTIKey(claims.ID)}
+	if claims.SessionID != "" {
+		keys = append(keys, revokedSessionKey(claims.SessionID))
+	}
+	n, err := db.Rdb.Exists(ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// isSessionRevoked indica si la sesión del token fue revocada (logout, logout-all, usuario desactivado)
+// o ya no existe. Es el respaldo sin Redis: el logout de un solo access token (jti) no se puede ver aquí,
+// pero logout también revoca su sesión.
+func isSessionRevoked(ctx context.Context, sidHex string) (bool, error) {
+	if sidHex == "" {
+		return false, nil
+	}
+	sid, err := primitive.ObjectIDFromHex(sidHex)
+	if err != nil {
+		return true, nil
+	}
+	var session Session
+	err = db.Mongo().Collection("sessions").FindOne(ctx, bson.M{"_id": sid},
+		options.FindOne().SetProjection(bson.M{"revokedAt": 1}),
+	).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return session.RevokedAt != nil, nil
+}
+
+func remainingTTL(claims *JWTClaims) time.Duration {
+	if claims.ExpiresAt == nil {
+		return accessTokenTTL()
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > 0 {
+		return ttl
+	}
+	return time.Second
+}
+
+func revokedJTIKey(jti string) string { return fmt.Sprintf("auth:revoked:jti:%s", jti) }
+
+func revokedSessionKey(sid string) string { return fmt.Sprintf("auth:revoked:sid:%s", sid) }
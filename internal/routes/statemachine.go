@@ -0,0 +1,325 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/streams"
+)
+
+// Estados de una transacción: [draft ->] pending -> review -> approved | rejected
+// (draft solo para las que llegan por el webhook de WhatsApp y hay que completar)
+const (
+	StatusDraft    = "draft"
+	StatusPending  = "pending"
+	StatusReview   = "review"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+var transactionStatuses = []string{StatusDraft, StatusPending, StatusReview, StatusApproved, StatusRejected}
+
+const (
+	// pendingEventGrace es cuánto se espera antes de dar por perdida la publicación de transaction.created
+	pendingEventGrace = time.Minute
+	maxPendingEvents  = 100
+)
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrNotAssignedReviewer = errors.New("transaction is under review by another reviewer")
+	ErrIncompleteDraft     = errors.New("draft is missing required fields: amount, reference, destination_account")
+)
+
+// InvalidTransitionError indica que la transacción no está en un estado desde el que se permita el cambio
+type InvalidTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid transition %s -> %s", e.From, e.To)
+}
+
+// StatusChange es una entrada del historial de estados de una transacción
+type StatusChange struct {
+	From      string    `json:"from" bson:"from"`
+	To        string    `json:"to" bson:"to"`
+	Actor     string    `json:"actor" bson:"actor"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+}
+
+// Actor identifica quién solicita un cambio de estado
+type Actor struct {
+	ID   string
+	Role string
+}
+
+// transition define un cambio de estado permitido: estados de origen, validaciones previas
+// y efectos que se ejecutan (en orden) una vez persistido el cambio
+type transition struct {
+	From    []string
+	Guards  []func(ctx context.Context, tx *Transaction, actor Actor) error
+	Effects []func(ctx context.Context, tx *Transaction)
+	// Notify marca la transacción con notification_pending en la misma actualización del estado,
+	// para que el aviso al comercio se encole aunque el proceso caiga antes de hacerlo
+	Notify bool
+}
+
+// transitions es la única definición de los cambios de estado; la clave es el estado destino
+var transitions = map[string]transition{
+	StatusPending: {
+		From:    []string{StatusDraft},
+		Guards:  []func(context.Context, *Transaction, Actor) error{guardCompleteDraft},
+		Effects: []func(context.Context, *Transaction){flagDuplicateReceipt, publishStatusEvent},
+	},
+	StatusReview: {
+		From:    []string{StatusPending},
+		Effects: []func(context.Context, *Transaction){signReceiptURL, publishStatusEvent},
+	},
+	StatusApproved: {
+		From:    []string{StatusReview},
+		Guards:  []func(context.Context, *Transaction, Actor) error{guardAssignedReviewer},
+		Effects: []func(context.Context, *Transaction){publishStatusEvent, enqueueMerchantNotifications},
+		Notify:  true,
+	},
+	StatusRejected: {
+		From:    []string{StatusReview},
+		Guards:  []func(context.Context, *Transaction, Actor) error{guardAssignedReviewer},
+		Effects: []func(context.Context, *Transaction){publishStatusEvent, enqueueMerchantNotifications},
+		Notify:  true,
+	},
+}
+
+// applyTransition lleva la transacción al estado `to` si la máquina de estados lo permite.
+// MongoDB es la fuente de verdad: la actualización es condicional al estado de origen (CAS),
+// y agrega la entrada correspondiente a status_history.
+func applyTransition(ctx context.Context, id primitive.ObjectID, to string, actor Actor, reason string) (*Transaction, error) {
+	t, ok := transitions[to]
+	if !ok {
+		return nil, &InvalidTransitionError{To: to}
+	}
+
+	transactions := db.Mongo().Collection("transactions")
+	var tx Transaction
+	if err := transactions.FindOne(ctx, bson.M{"_id": id}).Decode(&tx); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, err
+	}
+	if !contains(t.From, tx.Status) {
+		return nil, &InvalidTransitionError{From: tx.Status, To: to}
+	}
+	for _, guard := range t.Guards {
+		if err := guard(ctx, &tx, actor); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	change := StatusChange{From: tx.Status, To: to, Actor: actor.ID, Reason: reason, Timestamp: now}
+	set := bson.M{"status": to, "updatedAt": now}
+	if t.Notify {
+		set["notification_pending"] = true
+	}
+	err := transactions.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": tx.Status},
+		bson.M{
+			"$set":  set,
+			"$push": bson.M{"status_history": change},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&tx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// Otro request cambió el estado entre la lectura y la actualización
+			var current Transaction
+			if err := transactions.FindOne(ctx, bson.M{"_id": id}).Decode(&current); err != nil {
+				return nil, ErrTransactionNotFound
+			}
+			return nil, &InvalidTransitionError{From: current.Status, To: to}
+		}
+		return nil, err
+	}
+
+	setCachedStatus(ctx, id.Hex(), to)
+
+	for _, effect := range t.Effects {
+		effect(ctx, &tx)
+	}
+	return &tx, nil
+}
+
+// initialStatusChange es la primera entrada del historial al crear una transacción
+func initialStatusChange(status, actor string) StatusChange {
+	return StatusChange{To: status, Actor: actor, Timestamp: time.Now()}
+}
+
+// setCachedStatus refleja el estado en Redis (tx:<id>:status) para consumidores que lo leen de ahí
+func setCachedStatus(ctx context.Context, id, status string) {
+	if db.Rdb == nil {
+		return
+	}
+	if err := db.Rdb.Set(ctx, fmt.Sprintf("tx:%s:status", id), status, 0).Err(); err != nil {
+		log.Printf("Failed to cache status for transaction %s: %v", id, err)
+	}
+}
+
+// guardAssignedReviewer: solo quien tomó la transacción en revisión (o un admin) puede aprobarla o rechazarla
+func guardAssignedReviewer(ctx context.Context, tx *Transaction, actor Actor) error {
+	if actor.Role == RoleAdmin {
+		return nil
+	}
+	for i := len(tx.StatusHistory) - 1; i >= 0; i-- {
+		if tx.StatusHistory[i].To == StatusReview {
+			if tx.StatusHistory[i].Actor != actor.ID {
+				return ErrNotAssignedReviewer
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// guardCompleteDraft: un borrador solo pasa a pending con los datos mínimos para revisarlo
+func guardCompleteDraft(ctx context.Context, tx *Transaction, actor Actor) error {
+	if tx.Amount <= 0 || strings.TrimSpace(tx.Reference) == "" || strings.TrimSpace(tx.DestinationAccount) == "" {
+		return ErrIncompleteDraft
+	}
+	return nil
+}
+
+// flagDuplicateReceipt marca el borrador como posible duplicado al enviarlo (al crear por API se hace en createTransaction)
+func flagDuplicateReceipt(ctx context.Context, tx *Transaction) {
+	original, err := findDuplicateReceipt(ctx, tx)
+	if err != nil {
+		log.Printf("Duplicate receipt check failed for transaction %s: %v", tx.ID.Hex(), err)
+		return
+	}
+	if original == nil {
+		return
+	}
+	tx.PossibleDuplicate, tx.DuplicateOf = true, original.ID.Hex()
+	if _, err := db.Mongo().Collection("transactions").UpdateOne(ctx,
+		bson.M{"_id": tx.ID},
+		bson.M{"$set": bson.M{"possible_duplicate": true, "duplicate_of": tx.DuplicateOf}},
+	); err != nil {
+		log.Printf("Failed to flag duplicate for transaction %s: %v", tx.ID.Hex(), err)
+	}
+}
+
+// publishStatusEvent publica transaction.<status> en el stream de procesamiento para que el worker lo maneje
+func publishStatusEvent(ctx context.Context, tx *Transaction) {
+	if err := publishTransactionEvent(ctx, "transaction."+tx.Status, tx); err != nil {
+		log.Printf("Failed to publish transaction.%s for transaction %s: %v", tx.Status, tx.ID.Hex(), err)
+	}
+}
+
+// publishTransactionEvent agrega el evento al stream de procesamiento con la transacción completa como JSON
+func publishTransactionEvent(ctx context.Context, eventType string, tx *Transaction) error {
+	if db.Rdb == nil {
+		return nil
+	}
+	payloadBytes, _ := json.Marshal(tx)
+	event := map[string]interface{}{
+		"type":      eventType,
+		"data":      string(payloadBytes),
+		"timestamp": time.Now().Unix(),
+	}
+	stream := config.C.RedisStreamNS
+	if stream == "" {
+		stream = "valpago:transactions"
+	}
+	return db.Rdb.XAdd(ctx, streams.Args(stream, event)).Err()
+}
+
+// publishCreatedEvent publica transaction.created y quita la marca event_pending. Si falla (o no hay
+// Redis) la marca queda y RepublishPendingEvents lo reintenta.
+func publishCreatedEvent(ctx context.Context, tx *Transaction) error {
+	if db.Rdb == nil {
+		return nil
+	}
+	if err := publishTransactionEvent(ctx, "transaction.created", tx); err != nil {
+		return err
+	}
+	tx.EventPending = false
+	if _, err := db.Mongo().Collection("transactions").UpdateOne(ctx,
+		bson.M{"_id": tx.ID},
+		bson.M{"$unset": bson.M{"event_pending": ""}},
+	); err != nil {
+		log.Printf("Failed to clear event_pending for transaction %s: %v", tx.ID.Hex(), err)
+	}
+	return nil
+}
+
+// RepublishPendingEvents publica transaction.created de las transacciones creadas que no alcanzaron
+// a publicarlo (Redis caído o el proceso murió). Lo llama el dispatcher en cada vuelta.
+func RepublishPendingEvents(ctx context.Context) {
+	if db.Rdb == nil {
+		return
+	}
+	cursor, err := db.Mongo().Collection("transactions").Find(ctx,
+		bson.M{"event_pending": true, "createdAt": bson.M{"$lte": time.Now().Add(-pendingEventGrace)}},
+		options.Find().SetLimit(maxPendingEvents),
+	)
+	if err != nil {
+		log.Printf("Failed to look up unpublished transactions: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var tx Transaction
+		if err := cursor.Decode(&tx); err != nil {
+			continue
+		}
+		if err := publishCreatedEvent(ctx, &tx); err != nil {
+			log.Printf("Failed to republish transaction.created for transaction %s: %v", tx.ID.Hex(), err)
+			return
+		}
+	}
+}
+
+// actorFromContext arma el Actor a partir del usuario autenticado
+func actorFromContext(c echo.Context) Actor {
+	claims := currentClaims(c)
+	if claims == nil {
+		return Actor{}
+	}
+	return Actor{ID: claims.UserID, Role: claims.Role}
+}
+
+// transitionError traduce los errores de applyTransition a respuestas HTTP
+func transitionError(c echo.Context, err error) error {
+	var invalid *InvalidTransitionError
+	switch {
+	case errors.Is(err, ErrTransactionNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Transaction not found"})
+	case errors.Is(err, ErrNotAssignedReviewer):
+		return forbidden(c, err.Error())
+	case errors.Is(err, ErrIncompleteDraft):
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	case errors.As(err, &invalid):
+		if invalid.From == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid status: %s", invalid.To)})
+		}
+		return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("Invalid state: %s", invalid.From)})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update transaction"})
+}
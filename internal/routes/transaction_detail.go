@@ -0,0 +1,143 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/outbox"
+)
+
+// NotificationAttempt registra cada intento de avisar al comercio (ver outbox.Attempt)
+type NotificationAttempt = outbox.Attempt
+
+// ReviewerInfo resume al usuario que tomó o resolvió la revisión
+type ReviewerInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Lastname string `json:"lastname,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// TimelineEntry es un evento de la línea de tiempo de una transacción, ordenada cronológicamente
+type TimelineEntry struct {
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Detail    interface{} `json:"detail"`
+}
+
+// TransactionDetail es la respuesta de GET /api/transactions/:id
+type TransactionDetail struct {
+	Transaction
+	Intake               *CreateTransactionRequestSpanish `json:"intake,omitempty"`
+	ReviewedBy           *ReviewerInfo                    `json:"reviewed_by,omitempty"`
+	ResolvedBy           *ReviewerInfo                    `json:"resolved_by,omitempty"`
+	NotificationAttempts []NotificationAttempt            `json:"notification_attempts"`
+	Timeline             []TimelineEntry                  `json:"timeline"`
+}
+
+// getTransaction devuelve la transacción con su historial, revisores, intentos de notificación y payload original
+func getTransaction(c echo.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
+	}
+
+	ctx := c.Request().Context()
+	var tx Transaction
+	if err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"_id": id}).Decode(&tx); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Transaction not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	}
+
+	attempts := []NotificationAttempt{}
+	cursor, err := db.Mongo().Collection("notification_attempts").Find(ctx,
+		bson.M{"transactionId": id},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch notification attempts"})
+	}
+	defer cursor.Close(ctx)
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode notification attempts"})
+	}
+
+	signReceiptURL(ctx, &tx)
+
+	detail := TransactionDetail{
+		Transaction:          tx,
+		Intake:               tx.Intake,
+		NotificationAttempts: attempts,
+	}
+	if tx.StatusHistory == nil {
+		detail.StatusHistory = []StatusChange{}
+	}
+
+	// Quién tomó la revisión y quién la resolvió (la última entrada de cada tipo)
+	for i := len(tx.StatusHistory) - 1; i >= 0; i-- {
+		change := tx.StatusHistory[i]
+		switch {
+		case detail.ResolvedBy == nil && (change.To == StatusApproved || change.To == StatusRejected):
+			detail.ResolvedBy = reviewerInfo(ctx, change.Actor)
+		case detail.ReviewedBy == nil && change.To == StatusReview:
+			detail.ReviewedBy = reviewerInfo(ctx, change.Actor)
+		}
+	}
+
+	detail.Timeline = buildTimeline(tx, attempts)
+
+	return c.JSON(http.StatusOK, detail)
+}
+
+// reviewerInfo resuelve el actor del historial; los actores que no son usuarios (apikey:..., system) se devuelven solo con su ID
+func reviewerInfo(ctx context.Context, actor string) *ReviewerInfo {
+	if actor == "" {
+		return nil
+	}
+	info := &ReviewerInfo{ID: actor}
+	if strings.Contains(actor, ":") {
+		return info
+	}
+	user, err := loadUser(ctx, actor)
+	if err != nil || user == nil {
+		return info
+	}
+	info.Name, info.Lastname, info.Email, info.Role = user.Name, user.Lastname, user.Email, user.Role
+	return info
+}
+
+// buildTimeline mezcla creación, cambios de estado e intentos de notificación en orden cronológico
+func buildTimeline(tx Transaction, attempts []NotificationAttempt) []TimelineEntry {
+	timeline := []TimelineEntry{}
+	for _, change := range tx.StatusHistory {
+		entryType := "status_changed"
+		if change.From == "" {
+			entryType = "created"
+		}
+		timeline = append(timeline, TimelineEntry{Type: entryType, Timestamp: change.Timestamp, Detail: change})
+	}
+	if len(tx.StatusHistory) == 0 {
+		// Transacciones anteriores al historial de estados
+		timeline = append(timeline, TimelineEntry{Type: "created", Timestamp: tx.CreatedAt, Detail: map[string]string{"status": tx.Status}})
+	}
+	for _, attempt := range attempts {
+		timeline = append(timeline, TimelineEntry{Type: "notification_attempt", Timestamp: attempt.CreatedAt, Detail: attempt})
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Timestamp.Before(timeline[j].Timestamp)
+	})
+	return timeline
+}
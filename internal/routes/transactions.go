@@ -1,29 +1,21 @@
 package routes
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"github.com/usuario/valpago-backend/internal/config"
 	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/receipts"
 )
 
 type Transaction struct {
@@ -39,22 +31,46 @@ type Transaction struct {
 	SupportURL         string             `json:"support_url" bson:"support_url"`
 	Date               string             `json:"date" bson:"date"`
 	UserID             string             `json:"userId" bson:"userId"`
+	MerchantID         string             `json:"merchantId,omitempty" bson:"merchantId,omitempty"`
+	WhatsappMessageID  string             `json:"whatsapp_message_id,omitempty" bson:"whatsapp_message_id,omitempty"`
 	CreatedAt          time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt          time.Time          `json:"updatedAt" bson:"updatedAt"`
+	StatusHistory      []StatusChange     `json:"status_history" bson:"status_history"`
+	// Mismo comprobante (referencia + cuenta origen + monto) ya registrado en otra transacción
+	PossibleDuplicate bool   `json:"possible_duplicate" bson:"possible_duplicate"`
+	DuplicateOf       string `json:"duplicate_of,omitempty" bson:"duplicate_of,omitempty"`
+	// Hash perceptual (dHash) del comprobante descargado y transacciones con imagen casi idéntica
+	ReceiptHash      string   `json:"receipt_hash,omitempty" bson:"receipt_hash,omitempty"`
+	ReceiptHashBands []string `json:"-" bson:"receipt_hash_bands,omitempty"`
+	Suspect          bool     `json:"suspect" bson:"suspect"`
+	SimilarReceipts  []string `json:"similar_receipts,omitempty" bson:"similar_receipts,omitempty"`
+	// Llave del comprobante en el storage; al cliente solo se le entrega una URL firmada y temporal
+	ReceiptKey string `json:"-" bson:"receipt_key,omitempty"`
+	ReceiptURL string `json:"receipt_url,omitempty" bson:"-"`
+	// Estado de la descarga del comprobante que hace el worker (pending | ok | failed | expired)
+	ReceiptFetch *receipts.FetchState `json:"receipt_fetch,omitempty" bson:"receipt_fetch,omitempty"`
+	// NotificationPending indica que el aviso al comercio del último cambio de estado aún no se encoló
+	NotificationPending bool `json:"-" bson:"notification_pending,omitempty"`
+	// EventPending indica que transaction.created aún no se publicó en el stream de procesamiento
+	EventPending bool `json:"-" bson:"event_pending,omitempty"`
+	// IdempotencyKey es "<scope>:<Idempotency-Key>" del request que la creó (índice único)
+	IdempotencyKey string `json:"-" bson:"idempotency_key,omitempty"`
+	// Intake es el payload original recibido en createTransaction; solo se expone en el detalle
+	Intake *CreateTransactionRequestSpanish `json:"-" bson:"intake,omitempty"`
 }
 
 // Estructura para recibir datos en español
 type CreateTransactionRequestSpanish struct {
-	MetodoPago         string `json:"metodo_pago" validate:"required"`
-	Monto              string `json:"monto" validate:"required"`
-	CuentaConsignacion string `json:"cuenta_consignacion" validate:"required"`
-	Referencia         string `json:"referencia" validate:"required"`
-	CuentaOrigen       string `json:"cuenta_origen" validate:"required"`
-	Beneficiario       string `json:"beneficiario" validate:"required"`
-	TelWhatsappSend    string `json:"tel_whatsapp_send" validate:"required"`
-	Estado             string `json:"estado" validate:"required"`
-	URLSoport          string `json:"url_soporte" validate:"required"`
-	Date               string `json:"date" validate:"required"`
+	MetodoPago         string `json:"metodo_pago" bson:"metodo_pago" validate:"required"`
+	Monto              string `json:"monto" bson:"monto" validate:"required"`
+	CuentaConsignacion string `json:"cuenta_consignacion" bson:"cuenta_consignacion" validate:"required"`
+	Referencia         string `json:"referencia" bson:"referencia" validate:"required"`
+	CuentaOrigen       string `json:"cuenta_origen" bson:"cuenta_origen" validate:"required"`
+	Beneficiario       string `json:"beneficiario" bson:"beneficiario" validate:"required"`
+	TelWhatsappSend    string `json:"tel_whatsapp_send" bson:"tel_whatsapp_send" validate:"required"`
+	Estado             string `json:"estado" bson:"estado" validate:"required"`
+	URLSoport          string `json:"url_soporte" bson:"url_soporte" validate:"required"`
+	Date               string `json:"date" bson:"date" validate:"required"`
 }
 
 // Estructura para almacenar en inglés (estructura interna)
@@ -73,12 +89,13 @@ type CreateTransactionRequest struct {
 }
 
 type UpdateStatusRequest struct {
-	Status string `json:"status" validate:"required,oneof=pending review approved rejected"`
+	Status string `json:"status" validate:"required,oneof=draft pending review approved rejected"`
+	Reason string `json:"reason"`
 }
 
-type WebhookRequest struct {
-	Tel string `json:"tel"`
-	Msg string `json:"msg"`
+// TransitionRequest es el body opcional de approve/reject
+type TransitionRequest struct {
+	Reason string `json:"reason"`
 }
 
 // Función para mapear de español a inglés
@@ -104,15 +121,37 @@ func mapSpanishToEnglish(spanish CreateTransactionRequestSpanish, userID string)
 	}, nil
 }
 
+// findByIdempotencyKey busca la transacción creada con esa llave (nil si no hay)
+func findByIdempotencyKey(ctx context.Context, key string) (*Transaction, error) {
+	var tx Transaction
+	err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"idempotency_key": key}).Decode(&tx)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
 func createTransaction(c echo.Context) error {
-	// Check API key
-	apiKey := c.Request().Header.Get("x-api-key")
-	if apiKey == "" {
+	// La API key ya fue validada por requireAPIKey; el dueño de la transacción sale de ella
+	apiKey := currentAPIKey(c)
+	if apiKey == nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "API key required"})
 	}
 
-	// In production, validate API key against database
-	// For now, we'll accept any non-empty API key
+	// Reintento de un request que ya creó la transacción (p. ej. tras retomar un lock vencido)
+	idempotencyKey := currentIdempotencyKey(c)
+	if idempotencyKey != "" {
+		existing, err := findByIdempotencyKey(c.Request().Context(), idempotencyKey)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+		}
+		if existing != nil {
+			return c.JSON(http.StatusCreated, existing)
+		}
+	}
 
 	// Recibir datos en español
 	var spanishReq CreateTransactionRequestSpanish
@@ -120,36 +159,24 @@ func createTransaction(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 
-	// Obtener userID del header o del token JWT
-	userID := c.Request().Header.Get("user-id")
-	if userID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "User ID required in header"})
-	}
-
-	// Verificar que el usuario existe
+	// Verificar que el usuario dueño de la API key existe
+	userID := apiKey.UserID
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		fmt.Printf("Error parsing ObjectID: %v\n", err)
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID format"})
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "API key has an invalid owner"})
 	}
 
 	var user User
 	err = db.Mongo().Collection("users").FindOne(c.Request().Context(), bson.M{"_id": userObjectID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			fmt.Printf("User not found with ID: %s\n", userID)
-			// Vamos a verificar si hay usuarios en la base de datos
-			cursor, _ := db.Mongo().Collection("users").Find(c.Request().Context(), bson.M{})
-			var users []User
-			cursor.All(c.Request().Context(), &users)
-			fmt.Printf("Total users in database: %d\n", len(users))
-			for i, u := range users {
-				fmt.Printf("User %d: ID=%s, Email=%s\n", i+1, u.ID.Hex(), u.Email)
-			}
 			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
 	}
+	if !user.IsActive {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "API key owner is inactive"})
+	}
 
 	// Mapear de español a inglés
 	req, err := mapSpanishToEnglish(spanishReq, userID)
@@ -166,195 +193,104 @@ func createTransaction(c echo.Context) error {
 		SourceAccount:      req.SourceAccount,
 		Beneficiary:        req.Beneficiary,
 		WhatsappPhone:      req.WhatsappPhone,
-		Status:             req.Status,
+		Status:             StatusPending, // toda transacción entra a la máquina de estados como pending
 		SupportURL:         req.SupportURL,
 		Date:               req.Date,
 		UserID:             req.UserID,
+		MerchantID:         apiKey.MerchantID,
 		CreatedAt:          time.Now(),
 		UpdatedAt:          time.Now(),
+		StatusHistory:      []StatusChange{initialStatusChange(StatusPending, "apikey:"+apiKey.ID.Hex())},
+		Intake:             &spanishReq,
+		ReceiptFetch:       receipts.Pending(),
+		EventPending:       true,
+		IdempotencyKey:     idempotencyKey,
+	}
+
+	// Marcar comprobantes repetidos; no bloquea la creación, el revisor decide
+	original, err := findDuplicateReceipt(c.Request().Context(), &transaction)
+	if err != nil {
+		log.Printf("Duplicate receipt check failed: %v", err)
+	} else if original != nil {
+		transaction.PossibleDuplicate = true
+		transaction.DuplicateOf = original.ID.Hex()
 	}
 
 	result, err := db.Mongo().Collection("transactions").InsertOne(c.Request().Context(), transaction)
 	if err != nil {
+		if idempotencyKey != "" && mongo.IsDuplicateKeyError(err) {
+			// Otro intento con la misma llave la creó entre la búsqueda y el insert
+			if existing, err := findByIdempotencyKey(c.Request().Context(), idempotencyKey); err == nil && existing != nil {
+				return c.JSON(http.StatusCreated, existing)
+			}
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create transaction"})
 	}
 
 	transaction.ID = result.InsertedID.(primitive.ObjectID)
 
 	// Inicializar estado en Redis: pending (si Redis disponible)
-	if db.Rdb != nil {
-		statusKey := fmt.Sprintf("tx:%s:status", transaction.ID.Hex())
-		_ = db.Rdb.SetNX(c.Request().Context(), statusKey, "pending", 0).Err()
-	}
+	setCachedStatus(c.Request().Context(), transaction.ID.Hex(), StatusPending)
 
-	// Publicar en Redis Stream (procesamiento) el objeto completo como JSON
-	if db.Rdb != nil {
-		payloadBytes, _ := json.Marshal(transaction)
-		processing := map[string]interface{}{
-			"type":      "transaction.created",
-			"data":      string(payloadBytes),
-			"timestamp": time.Now().Unix(),
-		}
-		stream := config.C.RedisStreamNS
-		if stream == "" {
-			stream = "valpago:transactions"
-		}
-
-		err := db.Rdb.XAdd(c.Request().Context(), &redis.XAddArgs{
-			Stream: stream,
-			Values: processing,
-		}).Err()
-		if err != nil {
-			strError := fmt.Sprintf("Error XAdd: %v", err)
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": strError})
-		}
-
-		// No notificar al front aquí; el worker será quien publique los PENDING
+	// Publicar en Redis Stream (procesamiento) el objeto completo como JSON. La transacción ya existe:
+	// si falla, se responde igual 201 (un 5xx liberaría la Idempotency-Key y el reintento la duplicaría)
+	// y RepublishPendingEvents la publica después. No notificar al front aquí; el worker publica los PENDING.
+	if err := publishCreatedEvent(c.Request().Context(), &transaction); err != nil {
+		log.Printf("Failed to publish transaction.created for transaction %s: %v", transaction.ID.Hex(), err)
 	}
 
 	return c.JSON(http.StatusCreated, transaction)
 }
 
-// fetchAndUploadSupportImage intenta descargar la imagen desde Meta con Bearer token
-// Si falla, usa imagen local de fallback
-func fetchAndUploadSupportImage(ctx context.Context, supportURL string) (string, error) {
-	// Obtener URL real de la imagen desde Graph API
-	realImageURL, err := getRealImageURLFromMeta(ctx, supportURL)
-	if err != nil {
-		log.Printf("Error getting real image URL from Meta: %v", err)
-		return fallbackUpload()
-	}
-
-	// Descargar imagen real con Bearer
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realImageURL, nil)
-	if err != nil {
-		log.Println(err, "error NewRequestWithContext descargando imagen")
-		return fallbackUpload()
-	}
-	if config.C.BearerTokenMeta != "" {
-		req.Header.Set("Authorization", "Bearer "+config.C.BearerTokenMeta)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		if resp != nil {
-			resp.Body.Close()
-		}
-		log.Println(err, "error DefaultClient.Do imagen")
-		return fallbackUpload()
-	}
-	defer resp.Body.Close()
-
-	// Asegurar que es imagen
-	contentType := resp.Header.Get("Content-Type")
-
-	if contentType == "" {
-		// intentar detectar por algunos bytes
-		peek := make([]byte, 512)
-		n, _ := io.ReadFull(resp.Body, peek)
-		contentType = http.DetectContentType(peek[:n])
-		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(peek[:n]), resp.Body))
-	}
-	if !strings.HasPrefix(contentType, "image/") {
-		log.Println(contentType, "content type imagen no es image/")
-		return fallbackUpload()
-	}
-
-	data, err := io.ReadAll(resp.Body)
+// listTransactions lista transacciones con filtros, orden estable y paginación por cursor
+// (ver parseTransactionQuery para los parámetros soportados)
+func listTransactions(c echo.Context) error {
+	q, err := parseTransactionQuery(c)
 	if err != nil {
-		log.Println(err, "error ReadAll imagen")
-		return fallbackUpload()
-	}
-
-	// Nombre y extensión
-	ext := ".jpeg"
-	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
-		ext = exts[0]
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-	fileName := fmt.Sprintf("evidence_%d%s", time.Now().UnixNano(), ext)
-	log.Println(fileName, "fileName imagen")
-
-	// Subir a Supabase
-	/*url, err := uploadToSupabase(ctx, fileName, data, contentType)
+	filter, err := q.pageFilter()
 	if err != nil {
-		log.Println(err, "error uploadToSupabase imagen")
-		return fallbackUpload()
-	}
-	log.Println(url, "url fetchAndUploadSupportImage imagen")*/
-	url := fmt.Sprintf("data:%s;base64,%s", "image/jpeg", base64.StdEncoding.EncodeToString(data))
-	return url, nil
-}
-
-// getRealImageURLFromMeta obtiene la URL real de la imagen desde Graph API de Meta
-func getRealImageURLFromMeta(ctx context.Context, mid string) (string, error) {
-	// Construir URL de Graph API
-	graphURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s", mid)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, graphURL, nil)
-
-	if err != nil {
-		return "[graph otro ERROR]", err
-	}
-
-	if config.C.BearerTokenMeta != "" {
-		req.Header.Set("Authorization", "Bearer "+config.C.BearerTokenMeta)
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	ctx := c.Request().Context()
+	// Se pide un elemento extra para saber si hay otra página
+	opts := options.Find().SetSort(q.sort()).SetLimit(q.Limit + 1)
+	cursor, err := db.Mongo().Collection("transactions").Find(ctx, filter, opts)
 	if err != nil {
-		return "[graph.facebook ERROR]", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("Graph API request failed with status: %d", resp.StatusCode)
-	}
-
-	// Parsear respuesta JSON
-	var response struct {
-		URL string `json:"url"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch transactions"})
 	}
+	defer cursor.Close(ctx)
 
-	if response.URL == "" {
-		return "", fmt.Errorf("empty URL in Graph API response")
+	transactions := []Transaction{}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode transactions"})
 	}
 
-	return response.URL, nil
-}
-
-func fallbackUpload() (string, error) {
-	// Ruta relativa: internal/worker/img/Comprobante-test.jpeg
-	wd, _ := os.Getwd()
-	path := filepath.Join(wd, "internal", "worker", "img", "Comprobante-test.jpeg")
-	data, err := os.ReadFile(path)
+	total, err := db.Mongo().Collection("transactions").CountDocuments(ctx, q.Filter)
 	if err != nil {
-		return "", err
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to count transactions"})
 	}
-	// uploadToSupabase(ctx, fmt.Sprintf("fallback_%d.jpeg", time.Now().UnixNano()), data, "image/jpeg")
-	url := fmt.Sprintf("data:%s;base64,%s", "image/jpeg", base64.StdEncoding.EncodeToString(data))
-	return url, nil
-}
-
-func listTransactions(c echo.Context) error {
-	// Filtrar solo transacciones con estado PENDING
-	filter := bson.M{"status": "pending"}
 
-	cursor, err := db.Mongo().Collection("transactions").Find(c.Request().Context(), filter)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch transactions"})
+	hasMore := int64(len(transactions)) > q.Limit
+	nextCursor := ""
+	if hasMore {
+		transactions = transactions[:q.Limit]
+		nextCursor = q.nextCursor(transactions[len(transactions)-1])
 	}
-	defer cursor.Close(c.Request().Context())
 
-	var transactions []Transaction
-	if err = cursor.All(c.Request().Context(), &transactions); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode transactions"})
+	response := map[string]interface{}{
+		"transactions": transactions,
+		"pagination": map[string]interface{}{
+			"limit":       q.Limit,
+			"total":       total,
+			"has_more":    hasMore,
+			"next_cursor": nextCursor,
+		},
 	}
 
-	return c.JSON(http.StatusOK, transactions)
+	return c.JSON(http.StatusOK, response)
 }
 
 func updateTransactionStatus(c echo.Context) error {
@@ -369,36 +305,8 @@ func updateTransactionStatus(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 
-	// Update transaction status
-	result, err := db.Mongo().Collection("transactions").UpdateOne(
-		c.Request().Context(),
-		bson.M{"_id": id},
-		bson.M{
-			"$set": bson.M{
-				"status":     req.Status,
-				"updated_at": time.Now(),
-			},
-		},
-	)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update transaction"})
-	}
-
-	if result.MatchedCount == 0 {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Transaction not found"})
-	}
-
-	// Send notification via Redis stream
-	if db.Rdb != nil {
-		streamData := map[string]interface{}{
-			"transaction_id": id.Hex(),
-			"status":         req.Status,
-			"timestamp":      time.Now().Unix(),
-		}
-		db.Rdb.XAdd(c.Request().Context(), &redis.XAddArgs{
-			Stream: "valpago:notifications",
-			Values: streamData,
-		})
+	if _, err := applyTransition(c.Request().Context(), id, req.Status, actorFromContext(c), req.Reason); err != nil {
+		return transitionError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Transaction status updated successfully"})
@@ -406,87 +314,14 @@ func updateTransactionStatus(c echo.Context) error {
 
 // reviewTransaction: mueve estado de pending -> review de forma atómica y notifica
 func reviewTransaction(c echo.Context) error {
-	idStr := c.Param("id")
-	if _, err := primitive.ObjectIDFromHex(idStr); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
-	}
-
-	if db.Rdb == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
-	}
-
-	ctx := c.Request().Context()
-	statusKey := fmt.Sprintf("tx:%s:status", idStr)
-
-	// Lua para hacer CAS pending->review
-	script := redis.NewScript(`
-		local k = KEYS[1]
-		local cur = redis.call('GET', k)
-		if cur == false then return 'NOT_FOUND' end
-		if cur ~= 'pending' then return cur end
-		redis.call('SET', k, 'review')
-		return 'OK'
-	`)
-	res, err := script.Run(ctx, db.Rdb, []string{statusKey}).Result()
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Redis error"})
-	}
-	if s, _ := res.(string); s != "OK" {
-		if s == "NOT_FOUND" {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "Status not found"})
-		}
-		return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("Invalid state: %s", s)})
-	}
-
-	// Actualizar Mongo a REVIEW y cargar objeto para notificar
-	var tx Transaction
-	objID, _ := primitive.ObjectIDFromHex(idStr)
-
-	// Primero cargar la transacción para obtener el support_url actual
-	if err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"_id": objID}).Decode(&tx); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load transaction"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
 	}
 
-	// Intentar descargar y procesar la imagen de Meta
-	uploadedURL, err := fetchAndUploadSupportImage(c.Request().Context(), tx.SupportURL)
+	tx, err := applyTransition(c.Request().Context(), id, StatusReview, actorFromContext(c), "")
 	if err != nil {
-		// Si falla, usar la URL original y solo registrar el error (no bloquear la transacción)
-		log.Printf("Warning: Failed to fetch/upload support image, using original URL: %v", err)
-		uploadedURL = tx.SupportURL // Mantener URL original
-	}
-
-	// Actualizar estado y support_url en MongoDB
-	updateData := bson.M{
-		"status":      "review",
-		"updatedAt":   time.Now(),
-		"support_url": uploadedURL,
-	}
-	if _, err := db.Mongo().Collection("transactions").UpdateOne(
-		ctx,
-		bson.M{"_id": objID},
-		bson.M{"$set": updateData},
-	); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update transaction in Mongo"})
-	}
-
-	// Actualizar el objeto local para la respuesta
-	tx.Status = "review"
-	tx.SupportURL = uploadedURL
-	tx.UpdatedAt = time.Now()
-	payloadBytes, _ := json.Marshal(tx)
-
-	// Publicar evento de estado al stream de procesamiento para que el worker lo maneje
-	if db.Rdb != nil {
-		stateEvent := map[string]interface{}{
-			"type":      "transaction.review",
-			"data":      string(payloadBytes),
-			"timestamp": time.Now().Unix(),
-		}
-		stream := config.C.RedisStreamNS
-		if stream == "" {
-			stream = "valpago:transactions"
-		}
-		db.Rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: stateEvent})
+		return transitionError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, tx)
@@ -494,198 +329,34 @@ func reviewTransaction(c echo.Context) error {
 
 // approveTransaction: mueve estado de review -> approved y notifica
 func approveTransaction(c echo.Context) error {
-	idStr := c.Param("id")
-	if _, err := primitive.ObjectIDFromHex(idStr); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
-	}
-
-	if db.Rdb == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
-	}
-
-	ctx := c.Request().Context()
-	statusKey := fmt.Sprintf("tx:%s:status", idStr)
-
-	script := redis.NewScript(`
-		local k = KEYS[1]
-		local cur = redis.call('GET', k)
-		if cur == false then return 'NOT_FOUND' end
-		if cur ~= 'review' then return cur end
-		redis.call('SET', k, 'approved')
-		return 'OK'
-	`)
-	res, err := script.Run(ctx, db.Rdb, []string{statusKey}).Result()
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Redis error"})
-	}
-	if s, _ := res.(string); s != "OK" {
-		if s == "NOT_FOUND" {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "Status not found"})
-		}
-		return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("Invalid state: %s", s)})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
 	}
 
-	// Actualizar Mongo a APPROVED y cargar objeto para notificar
-	var tx Transaction
-	objID, _ := primitive.ObjectIDFromHex(idStr)
-	if _, err := db.Mongo().Collection("transactions").UpdateOne(
-		ctx,
-		bson.M{"_id": objID},
-		bson.M{"$set": bson.M{"status": "approved", "updatedAt": time.Now()}},
-	); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update transaction in Mongo"})
-	}
-	if err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"_id": objID}).Decode(&tx); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load transaction"})
-	}
-	payloadBytes, _ := json.Marshal(tx)
-
-	// Publicar evento de estado al stream de procesamiento para que el worker lo maneje
-	if db.Rdb != nil {
-		stateEvent := map[string]interface{}{
-			"type":      "transaction.approved",
-			"data":      string(payloadBytes),
-			"timestamp": time.Now().Unix(),
-		}
-		stream := config.C.RedisStreamNS
-		if stream == "" {
-			stream = "valpago:transactions"
-		}
-		db.Rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: stateEvent})
-	}
+	var req TransitionRequest
+	_ = c.Bind(&req)
 
-	// Después de actualizar MongoDB y antes de publicar al stream:
-	var merchant Merchant
-	err = db.Mongo().Collection("merchants").FindOne(
-		ctx,
-		bson.M{"accounts": tx.DestinationAccount},
-	).Decode(&merchant)
-
-	log.Printf("Merchant lookup for Phone %s resulted in error: %v", merchant.Phone, err)
-
-	if err == nil && merchant.Phone != "" {
-		log.Printf("********-*Inicia envio de notificacion: %s", tx.DestinationAccount)
-		// Si encontramos el merchant, enviamos la notificación
-		// Ignoramos cualquier error del webhook
-		_ = sendWebhookNotification(merchant.Phone, true)
-	} else {
-		log.Printf("No merchant found for account: %s", tx.DestinationAccount)
+	if _, err := applyTransition(c.Request().Context(), id, StatusApproved, actorFromContext(c), req.Reason); err != nil {
+		return transitionError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Transaction approved"})
 }
 
-// rejectTransaction: mueve estado de review -> rejected y notifica, actualiza Mongo
+// rejectTransaction: mueve estado de review -> rejected y notifica
 func rejectTransaction(c echo.Context) error {
-	idStr := c.Param("id")
-	if _, err := primitive.ObjectIDFromHex(idStr); err != nil {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid transaction ID"})
 	}
 
-	if db.Rdb == nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Redis unavailable"})
-	}
+	var req TransitionRequest
+	_ = c.Bind(&req)
 
-	ctx := c.Request().Context()
-	statusKey := fmt.Sprintf("tx:%s:status", idStr)
-
-	script := redis.NewScript(`
-        local k = KEYS[1]
-        local cur = redis.call('GET', k)
-        if cur == false then return 'NOT_FOUND' end
-        if cur ~= 'review' then return cur end
-        redis.call('SET', k, 'rejected')
-        return 'OK'
-    `)
-	res, err := script.Run(ctx, db.Rdb, []string{statusKey}).Result()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Redis error"})
-	}
-	if s, _ := res.(string); s != "OK" {
-		if s == "NOT_FOUND" {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "Status not found"})
-		}
-		return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("Invalid state: %s", s)})
-	}
-
-	// Actualizar Mongo a REJECTED y cargar objeto para notificar
-	var tx Transaction
-	objID, _ := primitive.ObjectIDFromHex(idStr)
-	if _, err := db.Mongo().Collection("transactions").UpdateOne(
-		ctx,
-		bson.M{"_id": objID},
-		bson.M{"$set": bson.M{"status": "rejected", "updatedAt": time.Now()}},
-	); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update transaction in Mongo"})
-	}
-	if err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"_id": objID}).Decode(&tx); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load transaction"})
-	}
-	payloadBytes, _ := json.Marshal(tx)
-
-	// Publicar evento de estado al stream de procesamiento para que el worker lo maneje
-	if db.Rdb != nil {
-		stateEvent := map[string]interface{}{
-			"type":      "transaction.rejected",
-			"data":      string(payloadBytes),
-			"timestamp": time.Now().Unix(),
-		}
-		stream := config.C.RedisStreamNS
-		if stream == "" {
-			stream = "valpago:transactions"
-		}
-		db.Rdb.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: stateEvent})
-	}
-
-	// Después de actualizar MongoDB y antes de publicar al stream:
-	var merchant Merchant
-	err = db.Mongo().Collection("merchants").FindOne(
-		ctx,
-		bson.M{"accounts": tx.DestinationAccount},
-	).Decode(&merchant)
-
-	if err == nil && merchant.Phone != "" {
-		// Si encontramos el merchant, enviamos la notificación
-		// Ignoramos cualquier error del webhook
-		_ = sendWebhookNotification(merchant.Phone, false)
+	if _, err := applyTransition(c.Request().Context(), id, StatusRejected, actorFromContext(c), req.Reason); err != nil {
+		return transitionError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Transaction rejected"})
 }
-
-func sendWebhookNotification(phone string, isApproved bool) error {
-	webhookURL := "https://n8n.altabase.com.co/webhook/6dbb2967-a477-47c7-800c-febdecb0ba50"
-
-	msg := "🚨Comprobante no válido ❌❌❌⛓️‍💥📵"
-	if isApproved {
-		msg = "💲Transaccion aprobada ✅✅✅🧾"
-	}
-
-	payload := WebhookRequest{
-		Tel: phone,
-		Msg: msg,
-	}
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error marshaling webhook payload: %v", err)
-		return err
-	}
-
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Error Post sending message webhook: %v", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Leer y mostrar el body del response
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-	} else {
-		log.Printf("Webhook response body: %s", string(bodyBytes))
-	}
-
-	return nil
-}
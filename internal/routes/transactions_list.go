@@ -0,0 +1,269 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultTransactionsLimit = 50
+	maxTransactionsLimit     = 200
+)
+
+// sortFields son los campos por los que se puede ordenar GET /api/transactions (?sort=-createdAt)
+var sortFields = map[string]string{
+	"createdAt": "createdAt",
+	"updatedAt": "updatedAt",
+	"amount":    "amount",
+}
+
+// transactionQuery es la consulta ya validada a partir de los query params
+type transactionQuery struct {
+	Filter    bson.M
+	SortField string
+	SortDesc  bool
+	Limit     int64
+	After     *pageCursor
+}
+
+// pageCursor es el contenido del cursor opaco: el último valor de orden visto y su _id (desempate)
+type pageCursor struct {
+	Field string    `json:"f"`
+	Desc  bool      `json:"d"`
+	ID    string    `json:"id"`
+	Time  time.Time `json:"t,omitempty"`
+	Num   float64   `json:"n,omitempty"`
+}
+
+// parseTransactionQuery construye filtro, orden y paginación para listar transacciones.
+// Sin ?status se listan las pending (comportamiento histórico de la cola); status=all quita el filtro.
+func parseTransactionQuery(c echo.Context) (*transactionQuery, error) {
+	q := &transactionQuery{Filter: bson.M{}, SortField: "createdAt", SortDesc: true, Limit: defaultTransactionsLimit}
+	params := c.QueryParams()
+
+	statuses := splitMulti(params["status"])
+	switch {
+	case len(statuses) == 0:
+		q.Filter["status"] = StatusPending
+	case contains(statuses, "all"):
+	default:
+		for _, s := range statuses {
+			if !contains(transactionStatuses, s) {
+				return nil, fmt.Errorf("invalid status: %s", s)
+			}
+		}
+		q.Filter["status"] = bson.M{"$in": statuses}
+	}
+
+	created := bson.M{}
+	if v := c.QueryParam("from"); v != "" {
+		t, err := parseQueryTime(v, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %v", err)
+		}
+		created["$gte"] = t
+	}
+	if v := c.QueryParam("to"); v != "" {
+		t, err := parseQueryTime(v, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %v", err)
+		}
+		created["$lt"] = t
+	}
+	if len(created) > 0 {
+		q.Filter["createdAt"] = created
+	}
+
+	amount := bson.M{}
+	if v := c.QueryParam("min_amount"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("invalid min_amount")
+		}
+		amount["$gte"] = n
+	}
+	if v := c.QueryParam("max_amount"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("invalid max_amount")
+		}
+		amount["$lte"] = n
+	}
+	if len(amount) > 0 {
+		q.Filter["amount"] = amount
+	}
+
+	for param, field := range map[string]string{
+		"payment_method":      "payment_method",
+		"destination_account": "destination_account",
+		"merchant":            "merchantId",
+		"user":                "userId",
+		"receipt_status":      "receipt_fetch.status",
+	} {
+		if values := splitMulti(params[param]); len(values) == 1 {
+			q.Filter[field] = values[0]
+		} else if len(values) > 1 {
+			q.Filter[field] = bson.M{"$in": values}
+		}
+	}
+
+	// Prefijo anclado y sensible a mayúsculas, para que use el índice de reference
+	if ref := strings.TrimSpace(c.QueryParam("reference")); ref != "" {
+		q.Filter["reference"] = bson.M{"$regex": "^" + regexp.QuoteMeta(ref)}
+	}
+
+	if v := c.QueryParam("possible_duplicate"); v != "" {
+		flag, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("invalid possible_duplicate")
+		}
+		q.Filter["possible_duplicate"] = flag
+	}
+
+	if v := c.QueryParam("suspect"); v != "" {
+		flag, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("invalid suspect")
+		}
+		q.Filter["suspect"] = flag
+	}
+
+	if v := c.QueryParam("sort"); v != "" {
+		desc := strings.HasPrefix(v, "-")
+		field, ok := sortFields[strings.TrimLeft(v, "-+")]
+		if !ok {
+			return nil, fmt.Errorf("invalid sort: %s", v)
+		}
+		q.SortField, q.SortDesc = field, desc
+	}
+
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("invalid limit")
+		}
+		if n > maxTransactionsLimit {
+			n = maxTransactionsLimit
+		}
+		q.Limit = int64(n)
+	}
+
+	if v := c.QueryParam("cursor"); v != "" {
+		cur, err := decodeCursor(v)
+		if err != nil || cur.Field != q.SortField || cur.Desc != q.SortDesc {
+			return nil, errors.New("invalid cursor")
+		}
+		q.After = cur
+	}
+
+	return q, nil
+}
+
+// pageFilter agrega al filtro la condición de keyset para continuar después del cursor
+func (q *transactionQuery) pageFilter() (bson.M, error) {
+	if q.After == nil {
+		return q.Filter, nil
+	}
+	id, err := primitive.ObjectIDFromHex(q.After.ID)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var value interface{}
+	switch q.SortField {
+	case "createdAt", "updatedAt":
+		value = q.After.Time
+	default:
+		value = q.After.Num
+	}
+
+	op := "$gt"
+	if q.SortDesc {
+		op = "$lt"
+	}
+	page := bson.M{"$or": bson.A{
+		bson.M{q.SortField: bson.M{op: value}},
+		bson.M{q.SortField: value, "_id": bson.M{op: id}},
+	}}
+
+	filter := bson.M{}
+	for k, v := range q.Filter {
+		filter[k] = v
+	}
+	filter["$and"] = bson.A{page}
+	return filter, nil
+}
+
+// sort devuelve el orden estable (campo + _id)
+func (q *transactionQuery) sort() bson.D {
+	dir := 1
+	if q.SortDesc {
+		dir = -1
+	}
+	return bson.D{{Key: q.SortField, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+// nextCursor construye el cursor que apunta después de la última transacción de la página
+func (q *transactionQuery) nextCursor(last Transaction) string {
+	cur := pageCursor{Field: q.SortField, Desc: q.SortDesc, ID: last.ID.Hex()}
+	switch q.SortField {
+	case "createdAt":
+		cur.Time = last.CreatedAt
+	case "updatedAt":
+		cur.Time = last.UpdatedAt
+	case "amount":
+		cur.Num = last.Amount
+	}
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur pageCursor
+	if err := json.Unmarshal(raw, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// splitMulti acepta tanto ?k=a&k=b como ?k=a,b
+func splitMulti(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// parseQueryTime acepta RFC3339 o YYYY-MM-DD; con endOfDay una fecha sin hora incluye el día completo
+func parseQueryTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
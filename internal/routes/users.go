@@ -11,6 +11,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/password"
 )
 
 type User struct {
@@ -23,13 +24,19 @@ type User struct {
 	Role      string             `json:"role" bson:"role"`
 	IsActive  bool               `json:"isActive" bson:"isActive"`
 	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	// Two-factor authentication (TOTP); los secretos nunca se serializan
+	MFAEnabled       bool     `json:"mfaEnabled" bson:"mfaEnabled"`
+	MFASecret        string   `json:"-" bson:"mfaSecret,omitempty"`
+	MFAPendingSecret string   `json:"-" bson:"mfaPendingSecret,omitempty"`
+	MFARecoveryCodes []string `json:"-" bson:"mfaRecoveryCodes,omitempty"`
+	MFALastCounter   int64    `json:"-" bson:"mfaLastCounter,omitempty"`
 }
 
 type CreateUserRequest struct {
 	Name     string `json:"name" validate:"required"`
 	Lastname string `json:"lastname" validate:"required"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6"`
+	Password string `json:"password" validate:"required"`
 	Phone    string `json:"phone" validate:"required"`
 	Role     string `json:"role"`
 }
@@ -56,6 +63,10 @@ func createUser(c echo.Context) error {
 		return c.JSON(http.StatusConflict, map[string]string{"error": "User already exists"})
 	}
 
+	if err := password.Validate(req.Password); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -65,7 +76,10 @@ func createUser(c echo.Context) error {
 	// Set default role if not provided
 	role := req.Role
 	if role == "" {
-		role = "user" // Default role
+		role = RoleUser // Default role
+	}
+	if !validRole(role) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role"})
 	}
 
 	// Create new user
@@ -157,6 +171,9 @@ func updateUser(c echo.Context) error {
 		update["phone"] = req.Phone
 	}
 	if req.Role != "" {
+		if !validRole(req.Role) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid role"})
+		}
 		update["role"] = req.Role
 	}
 	if req.IsActive != nil {
@@ -180,5 +197,12 @@ func updateUser(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 	}
 
+	// Desactivar un usuario cierra todas sus sesiones
+	if req.IsActive != nil && !*req.IsActive {
+		if _, err := revokeUserSessions(c.Request().Context(), id.Hex()); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "User updated but failed to revoke sessions"})
+		}
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "User updated successfully"})
 }
@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/receipts"
+)
+
+// maxWebhookBody limita el tamaño de los POST del webhook de WhatsApp
+const maxWebhookBody = 1 << 20
+
+// whatsappWebhook es el payload de WhatsApp Cloud API (solo los campos que usamos)
+type whatsappWebhook struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		ID      string `json:"id"`
+		Changes []struct {
+			Field string `json:"field"`
+			Value struct {
+				Metadata struct {
+					PhoneNumberID      string `json:"phone_number_id"`
+					DisplayPhoneNumber string `json:"display_phone_number"`
+				} `json:"metadata"`
+				Contacts []struct {
+					WaID    string `json:"wa_id"`
+					Profile struct {
+						Name string `json:"name"`
+					} `json:"profile"`
+				} `json:"contacts"`
+				Messages []whatsappMessage `json:"messages"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type whatsappMessage struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Image     *struct {
+		ID       string `json:"id"`
+		MimeType string `json:"mime_type"`
+		SHA256   string `json:"sha256"`
+		Caption  string `json:"caption"`
+	} `json:"image"`
+}
+
+// verifyWhatsappWebhook responde el challenge de suscripción (GET con hub.mode, hub.verify_token, hub.challenge)
+func verifyWhatsappWebhook(c echo.Context) error {
+	token := config.C.WhatsappVerifyToken
+	if token == "" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "WhatsApp webhook not configured"})
+	}
+	if c.QueryParam("hub.mode") != "subscribe" ||
+		!hmac.Equal([]byte(c.QueryParam("hub.verify_token")), []byte(token)) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Verification failed"})
+	}
+	return c.String(http.StatusOK, c.QueryParam("hub.challenge"))
+}
+
+// receiveWhatsappWebhook valida X-Hub-Signature-256 y convierte cada imagen recibida en una transacción draft.
+// Responde 200 aunque algún mensaje falle, para que Meta no reintente todo el lote.
+func receiveWhatsappWebhook(c echo.Context) error {
+	if config.C.WhatsappAppSecret == "" {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "WhatsApp webhook not configured"})
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxWebhookBody))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if !validHubSignature(body, c.Request().Header.Get("X-Hub-Signature-256"), config.C.WhatsappAppSecret) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid signature"})
+	}
+
+	var payload whatsappWebhook
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid payload"})
+	}
+
+	ctx := c.Request().Context()
+	created := 0
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			if change.Field != "messages" {
+				continue
+			}
+			for _, msg := range change.Value.Messages {
+				if msg.Type != "image" || msg.Image == nil {
+					continue
+				}
+				err := createDraftFromWhatsapp(ctx, msg)
+				if errors.Is(err, errDuplicateWhatsappMessage) {
+					continue
+				}
+				if err != nil {
+					log.Printf("Failed to create draft from WhatsApp message %s: %v", msg.ID, err)
+					continue
+				}
+				created++
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"created": created})
+}
+
+// validHubSignature compara "sha256=<hex>" con el HMAC-SHA256 del body usando el app secret
+func validHubSignature(body []byte, header, secret string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// errDuplicateWhatsappMessage indica que el mensaje ya había creado su draft (webhook reenviado)
+var errDuplicateWhatsappMessage = errors.New("whatsapp message already processed")
+
+// createDraftFromWhatsapp guarda la imagen como transacción draft ligada al teléfono del remitente.
+// El ID del mensaje es único: si Meta reenvía el webhook no se duplica la transacción.
+func createDraftFromWhatsapp(ctx context.Context, msg whatsappMessage) error {
+	intake := parseReceiptCaption(msg.Image.Caption)
+	intake.TelWhatsappSend = msg.From
+	intake.URLSoport = msg.Image.ID
+	intake.Estado = StatusDraft
+	amount, _ := parseAmount(intake.Monto)
+
+	now := time.Now()
+	transaction := Transaction{
+		PaymentMethod:      intake.MetodoPago,
+		Amount:             amount,
+		DestinationAccount: intake.CuentaConsignacion,
+		Reference:          intake.Referencia,
+		SourceAccount:      intake.CuentaOrigen,
+		Beneficiary:        intake.Beneficiario,
+		WhatsappPhone:      msg.From,
+		Status:             StatusDraft,
+		SupportURL:         msg.Image.ID,
+		Date:               intake.Date,
+		UserID:             config.C.WhatsappDefaultUserID,
+		WhatsappMessageID:  msg.ID,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+		StatusHistory:      []StatusChange{initialStatusChange(StatusDraft, "whatsapp:"+msg.From)},
+		Intake:             &intake,
+		ReceiptFetch:       receipts.Pending(),
+		EventPending:       true,
+	}
+	if ts, err := strconv.ParseInt(msg.Timestamp, 10, 64); err == nil && transaction.Date == "" {
+		transaction.Date = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+	}
+
+	result, err := db.Mongo().Collection("transactions").InsertOne(ctx, transaction)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errDuplicateWhatsappMessage
+		}
+		return err
+	}
+	transaction.ID = result.InsertedID.(primitive.ObjectID)
+	setCachedStatus(ctx, transaction.ID.Hex(), StatusDraft)
+
+	// El media ID de WhatsApp vence: el worker descarga el comprobante de inmediato. Si no se puede
+	// publicar, el draft ya existe y RepublishPendingEvents lo publica después.
+	if err := publishCreatedEvent(ctx, &transaction); err != nil {
+		log.Printf("Failed to publish transaction.created for transaction %s: %v", transaction.ID.Hex(), err)
+	}
+	return nil
+}
+
+// captionFields asocia las etiquetas que suelen venir en el caption con el campo del intake
+var captionFields = []struct {
+	re  *regexp.Regexp
+	set func(r *CreateTransactionRequestSpanish, v string)
+}{
+	{regexp.MustCompile(`(?i)^(monto|valor|total)$`), func(r *CreateTransactionRequestSpanish, v string) { r.Monto = v }},
+	{regexp.MustCompile(`(?i)^(referencia|ref|comprobante|n[uú]mero de comprobante)$`), func(r *CreateTransactionRequestSpanish, v string) { r.Referencia = v }},
+	{regexp.MustCompile(`(?i)^(m[eé]todo( de pago)?|medio( de pago)?|banco)$`), func(r *CreateTransactionRequestSpanish, v string) { r.MetodoPago = v }},
+	{regexp.MustCompile(`(?i)^(cuenta( destino| consignaci[oó]n)?|destino)$`), func(r *CreateTransactionRequestSpanish, v string) { r.CuentaConsignacion = v }},
+	{regexp.MustCompile(`(?i)^(cuenta origen|origen)$`), func(r *CreateTransactionRequestSpanish, v string) { r.CuentaOrigen = v }},
+	{regexp.MustCompile(`(?i)^(beneficiario|para)$`), func(r *CreateTransactionRequestSpanish, v string) { r.Beneficiario = v }},
+	{regexp.MustCompile(`(?i)^fecha$`), func(r *CreateTransactionRequestSpanish, v string) { r.Date = v }},
+}
+
+var captionAmount = regexp.MustCompile(`\$?\s*(\d{1,3}([.,]\d{3})+|\d+)([.,]\d{1,2})?`)
+
+// parseReceiptCaption interpreta líneas "etiqueta: valor" del caption; si no hay monto etiquetado,
+// toma el primer número del texto
+func parseReceiptCaption(caption string) CreateTransactionRequestSpanish {
+	var r CreateTransactionRequestSpanish
+	for _, line := range strings.Split(caption, "\n") {
+		label, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		label, value = strings.TrimSpace(label), strings.TrimSpace(value)
+		for _, f := range captionFields {
+			if f.re.MatchString(label) {
+				f.set(&r, value)
+				break
+			}
+		}
+	}
+	if r.Monto == "" {
+		r.Monto = captionAmount.FindString(caption)
+	}
+	r.Monto = strings.TrimSpace(r.Monto)
+	return r
+}
+
+// parseAmount acepta montos como "50000", "$50.000", "50,000.50" o "50.000,50"
+func parseAmount(v string) (float64, error) {
+	v = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(v), "$"))
+	v = strings.ReplaceAll(v, " ", "")
+	lastDot, lastComma := strings.LastIndex(v, "."), strings.LastIndex(v, ",")
+	decimalSep := -1
+	if lastDot > lastComma && len(v)-lastDot-1 <= 2 {
+		decimalSep = lastDot
+	} else if lastComma > lastDot && len(v)-lastComma-1 <= 2 {
+		decimalSep = lastComma
+	}
+	var b strings.Builder
+	for i, ch := range v {
+		switch {
+		case ch >= '0' && ch <= '9':
+			b.WriteRune(ch)
+		case i == decimalSep:
+			b.WriteByte('.')
+		}
+	}
+	return strconv.ParseFloat(b.String(), 64)
+}
@@ -3,6 +3,7 @@ package sse
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -12,8 +13,19 @@ import (
 	"github.com/usuario/valpago-backend/internal/db"
 )
 
-func Register(e *echo.Echo) {
-	e.GET("/api/sse", handleSSE)
+var (
+	closing   = make(chan struct{})
+	closeOnce sync.Once
+)
+
+// Shutdown cierra las conexiones abiertas con un evento close que indica al cliente cuándo reconectar.
+// http.Server.Shutdown no interrumpe las conexiones SSE, así que se llama al empezar el apagado.
+func Shutdown() {
+	closeOnce.Do(func() { close(closing) })
+}
+
+func Register(e *echo.Echo, m ...echo.MiddlewareFunc) {
+	e.GET("/api/sse", handleSSE, m...)
 }
 
 func handleSSE(c echo.Context) error {
@@ -93,6 +105,11 @@ func handleSSE(c echo.Context) error {
 				return err
 			}
 			c.Response().Flush()
+		case <-closing:
+			// El navegador reconecta tras retry ms (a otra instancia o a esta ya reiniciada)
+			fmt.Fprintf(c.Response(), "retry: %d\nevent: close\ndata: {\"reason\":\"shutdown\"}\n\n", config.C.SSERetryMillis)
+			c.Response().Flush()
+			return nil
 		case <-c.Request().Context().Done():
 			// Ensure goroutine stops
 			cancel()
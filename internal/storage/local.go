@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalPathPrefix es la ruta pública bajo la que la API sirve los archivos del backend local
+const LocalPathPrefix = "/files/"
+
+// Local guarda los objetos en disco; las URLs firmadas apuntan a la propia API (GET /files/<key>)
+// y se validan con HMAC-SHA256 sobre la llave y la expiración.
+type Local struct {
+	Dir     string
+	BaseURL string
+	secret  []byte
+}
+
+func NewLocal(dir, baseURL, secret string) *Local {
+	return &Local{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/"), secret: []byte(secret)}
+}
+
+func (l *Local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	// Escribir en un temporal y renombrar para no dejar archivos a medias
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (l *Local) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if len(l.secret) == 0 {
+		return "", errors.New("local storage signing secret not configured")
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", expires)
+	q.Set("signature", l.sign(key, expires))
+	return l.BaseURL + LocalPathPrefix + key + "?" + q.Encode(), nil
+}
+
+// Open verifica la firma de una URL generada por SignedURL y abre el archivo.
+// Devuelve el contenido y su content type (deducido de la extensión).
+func (l *Local) Open(key, expires, signature string) (io.ReadCloser, string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return nil, "", errors.New("url expired")
+	}
+	if len(l.secret) == 0 || !hmac.Equal([]byte(l.sign(key, expires)), []byte(signature)) {
+		return nil, "", errors.New("invalid signature")
+	}
+	path, err := l.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return f, contentType, nil
+}
+
+func (l *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(mac, "%s\n%s", key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,93 @@
+// Package storage guarda los comprobantes en un object storage (disco local, S3 compatible o Supabase)
+// y genera URLs firmadas y con expiración para descargarlos.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/usuario/valpago-backend/internal/config"
+)
+
+const (
+	BackendLocal    = "local"
+	BackendS3       = "s3"
+	BackendSupabase = "supabase"
+)
+
+var (
+	ErrNotFound     = errors.New("object not found")
+	ErrInvalidKey   = errors.New("invalid object key")
+	ErrNotAvailable = errors.New("storage not initialized")
+)
+
+// Storage guarda objetos por llave (p. ej. receipts/<txid>/<nombre>.jpeg)
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// SignedURL devuelve una URL de descarga válida durante ttl
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+var current Storage
+
+// New construye el backend indicado a partir de la configuración
+func New(backend string) (Storage, error) {
+	switch backend {
+	case BackendLocal:
+		return NewLocal(config.C.StorageLocalDir, config.C.PublicBaseURL, config.C.StorageSigningSecret), nil
+	case BackendS3:
+		if config.C.S3Endpoint == "" || config.C.S3Bucket == "" || config.C.S3AccessKey == "" || config.C.S3SecretKey == "" {
+			return nil, fmt.Errorf("s3 storage not configured")
+		}
+		return NewS3(config.C.S3Endpoint, config.C.S3Region, config.C.S3Bucket, config.C.S3AccessKey, config.C.S3SecretKey, config.C.S3PathStyle), nil
+	case BackendSupabase:
+		if config.C.SupabaseURLProject == "" || config.C.SupabaseBucket == "" || config.C.SupabaseAPIKey == "" {
+			return nil, fmt.Errorf("supabase storage not configured")
+		}
+		return NewSupabase(config.C.SupabaseURLProject, config.C.SupabaseBucket, config.C.SupabaseAPIKey), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// Init crea el backend configurado en STORAGE_BACKEND y lo deja como el de la aplicación
+func Init() error {
+	s, err := New(config.C.StorageBackend)
+	if err != nil {
+		return err
+	}
+	current = s
+	return nil
+}
+
+// RequireShared falla con storage local no compartido. Sirve para los procesos que descargan
+// comprobantes sin servir /files (cmd/worker, o la API con el worker aparte): lo que guarden en su
+// disco la API no lo encontraría, salvo que STORAGE_LOCAL_DIR sea un volumen común (STORAGE_LOCAL_SHARED=true).
+func RequireShared() error {
+	if config.C.StorageBackend == BackendLocal && !config.C.StorageLocalShared {
+		return fmt.Errorf("local storage requires STORAGE_LOCAL_SHARED=true (a STORAGE_LOCAL_DIR volume shared with the API) when the worker runs in a separate process")
+	}
+	return nil
+}
+
+// Default devuelve el backend inicializado con Init (nil si no se inicializó)
+func Default() Storage {
+	return current
+}
+
+// cleanKey valida la llave: relativa, sin segmentos vacíos ni "..", para que no se pueda salir del bucket/directorio
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" {
+		return "", ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, "\\") {
+			return "", ErrInvalidKey
+		}
+	}
+	return key, nil
+}
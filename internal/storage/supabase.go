@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Supabase guarda objetos en un bucket (privado) de Supabase Storage y firma las descargas con su API
+type Supabase struct {
+	ProjectURL string
+	Bucket     string
+	APIKey     string
+	Client     *http.Client
+}
+
+func NewSupabase(projectURL, bucket, apiKey string) *Supabase {
+	return &Supabase{
+		ProjectURL: strings.TrimRight(projectURL, "/"),
+		Bucket:     bucket,
+		APIKey:     apiKey,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *Supabase) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	// Endpoint de Storage: POST /storage/v1/object/{bucket}/{path}
+	url := fmt.Sprintf("%s/storage/v1/object/%s/%s", s.ProjectURL, s.Bucket, key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	s.authorize(req)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("x-upsert", "true")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("supabase upload failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// SignedURL usa POST /storage/v1/object/sign/{bucket}/{path}, que devuelve una ruta relativa con token
+func (s *Supabase) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(map[string]int{"expiresIn": int(ttl.Seconds())})
+	url := fmt.Sprintf("%s/storage/v1/object/sign/%s/%s", s.ProjectURL, s.Bucket, key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	s.authorize(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("supabase sign failed with status %d: %s", resp.StatusCode, string(raw))
+	}
+
+	var signed struct {
+		SignedURL string `json:"signedURL"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return "", err
+	}
+	if signed.SignedURL == "" {
+		return "", fmt.Errorf("empty signedURL in supabase response")
+	}
+	return s.ProjectURL + "/storage/v1" + signed.SignedURL, nil
+}
+
+func (s *Supabase) authorize(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("apikey", s.APIKey)
+}
@@ -0,0 +1,223 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+const (
+	// archiveBatch es cuántas entradas se copian por lectura del stream
+	archiveBatch = 500
+	// maxArchiveBatches limita lo que hace una vuelta para no bloquear la siguiente con un backlog grande
+	maxArchiveBatches = 20
+)
+
+// ArchivedEvent es una entrada del stream de procesamiento guardada en la colección events
+type ArchivedEvent struct {
+	Stream        string      `bson:"stream" json:"stream"`
+	EntryID       string      `bson:"entryId" json:"entry_id"`
+	Type          string      `bson:"type" json:"type"`
+	TransactionID string      `bson:"transactionId,omitempty" json:"transaction_id,omitempty"`
+	Data          interface{} `bson:"data,omitempty" json:"data,omitempty"`
+	CreatedAt     time.Time   `bson:"createdAt" json:"created_at"`
+	ArchivedAt    time.Time   `bson:"archivedAt" json:"archived_at"`
+}
+
+// ArchiveRun es el resultado de una vuelta del archivador
+type ArchiveRun struct {
+	At       time.Time `json:"at"`
+	Archived int       `json:"archived"`
+	Trimmed  int64     `json:"trimmed"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var (
+	lastRunMu sync.Mutex
+	lastRun   *ArchiveRun
+)
+
+// LastRun devuelve la última vuelta del archivador en este proceso (nil si aquí no corre)
+func LastRun() *ArchiveRun {
+	lastRunMu.Lock()
+	defer lastRunMu.Unlock()
+	if lastRun == nil {
+		return nil
+	}
+	run := *lastRun
+	return &run
+}
+
+// RunArchiver archiva y recorta el stream de procesamiento cada STREAM_ARCHIVE_INTERVAL_SECONDS
+// hasta que se cancele ctx. Puede correr en varios procesos a la vez: el archivado es idempotente.
+func RunArchiver(ctx context.Context) {
+	if config.C.StreamRetentionHours <= 0 {
+		log.Println("Stream retention disabled, skipping archiver...")
+		return
+	}
+	interval := time.Duration(config.C.StreamArchiveIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	for {
+		run := Archive(ctx, config.C.RedisStreamNS)
+		lastRunMu.Lock()
+		lastRun = &run
+		lastRunMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Archive copia a Mongo las entradas más antiguas que STREAM_RETENTION_HOURS que todos los grupos
+// ya confirmaron, y recorta el stream (XTRIM MINID ~) solo hasta la última copiada.
+func Archive(ctx context.Context, stream string) ArchiveRun {
+	run := ArchiveRun{At: time.Now()}
+
+	upper, ok, err := archiveBound(ctx, stream, time.Now().Add(-time.Duration(config.C.StreamRetentionHours)*time.Hour))
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Stream archiver for %s failed: %v", stream, err)
+		return run
+	}
+	if !ok {
+		return run
+	}
+
+	collection := db.Mongo().Collection("events")
+	start := "-"
+	var last string
+	for i := 0; i < maxArchiveBatches && ctx.Err() == nil; i++ {
+		messages, err := db.Rdb.XRangeN(ctx, stream, start, "("+upper.String(), archiveBatch).Result()
+		if err != nil {
+			run.Error = err.Error()
+			break
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		docs := make([]interface{}, 0, len(messages))
+		now := time.Now()
+		for _, m := range messages {
+			docs = append(docs, archivedEvent(stream, m, now))
+		}
+		if _, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil && !onlyDuplicateKeys(err) {
+			run.Error = err.Error()
+			break
+		}
+
+		run.Archived += len(messages)
+		last = messages[len(messages)-1].ID
+		start = "(" + last
+	}
+
+	// MINID ~ nunca borra entradas desde el ID indicado en adelante: solo puede quedarse corto
+	if last != "" {
+		id, _ := parseID(last)
+		trimmed, err := db.Rdb.XTrimMinIDApprox(ctx, stream, id.next().String(), 0).Result()
+		if err != nil {
+			run.Error = err.Error()
+		}
+		run.Trimmed = trimmed
+	}
+
+	if run.Error != "" {
+		log.Printf("Stream archiver for %s failed: %s", stream, run.Error)
+	}
+	if run.Archived > 0 {
+		log.Printf("Archived %d entries of %s (%d trimmed)", run.Archived, stream, run.Trimmed)
+	}
+	return run
+}
+
+// archiveBound calcula el ID (exclusivo) hasta el que se puede archivar: el menor entre el corte
+// por antigüedad, la entrada pendiente más antigua de cada grupo y la última que cada grupo leyó.
+// Sin grupos no hay nada confirmado, así que no se archiva.
+func archiveBound(ctx context.Context, stream string, cutoff time.Time) (entryID, bool, error) {
+	groups, err := db.Rdb.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		if isNoSuchKey(err) {
+			return entryID{}, false, nil
+		}
+		return entryID{}, false, err
+	}
+	if len(groups) == 0 {
+		return entryID{}, false, nil
+	}
+
+	upper := idAt(cutoff)
+	for _, g := range groups {
+		delivered, err := parseID(g.LastDeliveredID)
+		if err != nil {
+			return entryID{}, false, err
+		}
+		if d := delivered.next(); d.less(upper) {
+			upper = d
+		}
+		if g.Pending == 0 {
+			continue
+		}
+		pending, err := db.Rdb.XPending(ctx, stream, g.Name).Result()
+		if err != nil {
+			return entryID{}, false, err
+		}
+		if lower, err := parseID(pending.Lower); err == nil && lower.less(upper) {
+			upper = lower
+		}
+	}
+	return upper, true, nil
+}
+
+func archivedEvent(stream string, m redis.XMessage, now time.Time) ArchivedEvent {
+	ev := ArchivedEvent{Stream: stream, EntryID: m.ID, ArchivedAt: now}
+	if id, err := parseID(m.ID); err == nil {
+		ev.CreatedAt = id.time()
+	}
+	ev.Type, _ = m.Values["type"].(string)
+
+	raw, _ := m.Values["data"].(string)
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &data); err == nil {
+		ev.Data = data
+		if id, ok := data["_id"].(string); ok {
+			ev.TransactionID = id
+		}
+	} else if raw != "" {
+		ev.Data = raw
+	}
+	return ev
+}
+
+// onlyDuplicateKeys indica que todos los errores del InsertMany son entradas ya archivadas
+func onlyDuplicateKeys(err error) bool {
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil {
+		return false
+	}
+	for _, e := range bulk.WriteErrors {
+		if e.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+func isNoSuchKey(err error) bool {
+	return err != nil && err.Error() == "ERR no such key"
+}
@@ -0,0 +1,80 @@
+package streams
+
+import (
+	"context"
+	"time"
+
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// Stats son las métricas de un stream: tamaño, antigüedad y, por grupo, pendientes y lag
+type Stats struct {
+	Stream           string       `json:"stream"`
+	Length           int64        `json:"length"`
+	MaxLen           int64        `json:"max_len"`
+	FirstEntryID     string       `json:"first_entry_id,omitempty"`
+	LastEntryID      string       `json:"last_entry_id,omitempty"`
+	OldestAgeSeconds int64        `json:"oldest_age_seconds"`
+	Groups           []GroupStats `json:"groups"`
+}
+
+// GroupStats es el estado de un grupo de consumidores. Lag son las entradas que aún no leyó
+// (Redis 7+; queda en 0 si no puede calcularlo, p. ej. tras un XDEL en medio del stream).
+type GroupStats struct {
+	Name            string          `json:"name"`
+	Pending         int64           `json:"pending"`
+	Lag             int64           `json:"lag"`
+	LastDeliveredID string          `json:"last_delivered_id"`
+	Consumers       []ConsumerStats `json:"consumers"`
+}
+
+type ConsumerStats struct {
+	Name        string `json:"name"`
+	Pending     int64  `json:"pending"`
+	IdleSeconds int64  `json:"idle_seconds"`
+}
+
+// Info lee las métricas del stream; uno que aún no existe se informa vacío
+func Info(ctx context.Context, stream string) (*Stats, error) {
+	stats := &Stats{Stream: stream, MaxLen: MaxLen(stream), Groups: []GroupStats{}}
+
+	info, err := db.Rdb.XInfoStream(ctx, stream).Result()
+	if err != nil {
+		if isNoSuchKey(err) {
+			return stats, nil
+		}
+		return nil, err
+	}
+	stats.Length = info.Length
+	stats.FirstEntryID, stats.LastEntryID = info.FirstEntry.ID, info.LastEntry.ID
+	if id, err := parseID(info.FirstEntry.ID); err == nil {
+		stats.OldestAgeSeconds = int64(time.Since(id.time()).Seconds())
+	}
+
+	groups, err := db.Rdb.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		group := GroupStats{
+			Name:            g.Name,
+			Pending:         g.Pending,
+			Lag:             g.Lag,
+			LastDeliveredID: g.LastDeliveredID,
+			Consumers:       []ConsumerStats{},
+		}
+		consumers, err := db.Rdb.XInfoConsumers(ctx, stream, g.Name).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range consumers {
+			group.Consumers = append(group.Consumers, ConsumerStats{
+				Name:        c.Name,
+				Pending:     c.Pending,
+				IdleSeconds: int64(c.Idle.Seconds()),
+			})
+		}
+		stats.Groups = append(stats.Groups, group)
+	}
+	return stats, nil
+}
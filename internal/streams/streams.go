@@ -0,0 +1,78 @@
+// Package streams define la retención de los streams de Redis: el tope aproximado con que se
+// publican las entradas, el archivado en Mongo de las ya procesadas y las métricas de cada stream.
+package streams
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/usuario/valpago-backend/internal/config"
+)
+
+// Args arma el XADD con el tope aproximado (MAXLEN ~) configurado para el stream
+func Args(stream string, values map[string]interface{}) *redis.XAddArgs {
+	args := &redis.XAddArgs{Stream: stream, Values: values}
+	if n := MaxLen(stream); n > 0 {
+		args.MaxLen, args.Approx = n, true
+	}
+	return args
+}
+
+// MaxLen es el tope configurado del stream; 0 si no tiene. El stream de procesamiento no tiene:
+// un MAXLEN borraría entradas aún sin entregar ni archivar si el worker se atrasa, así que solo lo
+// recorta el archivador (XTRIM MINID) después de copiarlas.
+func MaxLen(stream string) int64 {
+	switch stream {
+	case config.C.RedisNotificationsStream:
+		return int64(config.C.NotificationsStreamMaxLen)
+	case config.C.RedisStreamNS + ":dlq": // worker.DLQStream
+		return int64(config.C.DLQMaxLen)
+	}
+	return 0
+}
+
+// entryID es un ID de stream (<ms>-<seq>) para comparar y calcular límites
+type entryID struct {
+	ms, seq uint64
+}
+
+func parseID(s string) (entryID, error) {
+	msPart, seqPart, found := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return entryID{}, fmt.Errorf("invalid stream ID %q", s)
+	}
+	var seq uint64
+	if found {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return entryID{}, fmt.Errorf("invalid stream ID %q", s)
+		}
+	}
+	return entryID{ms, seq}, nil
+}
+
+// idAt es el primer ID posible en el instante t
+func idAt(t time.Time) entryID {
+	return entryID{ms: uint64(t.UnixMilli())}
+}
+
+func (id entryID) less(o entryID) bool {
+	return id.ms < o.ms || (id.ms == o.ms && id.seq < o.seq)
+}
+
+// next es el ID inmediatamente posterior
+func (id entryID) next() entryID {
+	return entryID{id.ms, id.seq + 1}
+}
+
+func (id entryID) time() time.Time {
+	return time.UnixMilli(int64(id.ms))
+}
+
+func (id entryID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
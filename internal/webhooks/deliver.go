@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/notify"
+)
+
+// maxResponseBody limita lo que se lee de la respuesta del comercio
+const maxResponseBody = 4096
+
+// Event es el cuerpo JSON de cada entrega
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// NewEvent serializa el evento
+func NewEvent(id, eventType string, data interface{}) ([]byte, error) {
+	return json.Marshal(Event{ID: id, Type: eventType, CreatedAt: time.Now().UTC(), Data: data})
+}
+
+var client = &http.Client{}
+
+// Deliver firma y envía el evento al endpoint con los secretos vigentes en este momento,
+// de modo que un reintento después de rotar el secreto ya use el nuevo
+func Deliver(ctx context.Context, e *Endpoint, deliveryID, eventType string, body []byte) (*notify.Response, error) {
+	if e.Disabled {
+		return nil, ErrDisabled
+	}
+	timeout := time.Duration(config.C.WebhookTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ValPago-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderSignature, Sign(e.Secrets(time.Now()), time.Now(), body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("%s %s: %w", urlErr.Op, req.URL.Host, urlErr.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	result := &notify.Response{StatusCode: resp.StatusCode, Body: strings.ToValidUTF8(string(respBody), "")}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result, fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return result, nil
+}
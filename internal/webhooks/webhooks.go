@@ -0,0 +1,220 @@
+// Package webhooks administra los endpoints que registran los comercios para recibir eventos de sus
+// transacciones y firma cada entrega con HMAC-SHA256.
+//
+// La cabecera X-ValPago-Signature tiene la forma "t=<unix>,v1=<hex>", donde la firma es
+// HMAC-SHA256(secret, "<t>.<body>"). Durante la rotación del secreto se envía un v1 por cada
+// secreto vigente. El receptor debe rechazar timestamps con más de unos minutos de diferencia.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+const (
+	// Channel identifica las entregas a endpoints de comercio en el outbox
+	Channel = "merchant_webhook"
+
+	EventApproved = "transaction.approved"
+	EventRejected = "transaction.rejected"
+	EventPing     = "ping"
+	// EventAll suscribe el endpoint a todos los eventos
+	EventAll = "*"
+
+	HeaderSignature = "X-ValPago-Signature"
+	HeaderEvent     = "X-ValPago-Event"
+	HeaderDelivery  = "X-ValPago-Delivery"
+
+	// DefaultTolerance es la diferencia máxima de reloj que acepta Verify
+	DefaultTolerance = 5 * time.Minute
+
+	secretPrefix = "whsec_"
+)
+
+// Events son los eventos a los que se puede suscribir un endpoint
+var Events = []string{EventApproved, EventRejected}
+
+var (
+	ErrNotFound = errors.New("webhook endpoint not found")
+	// ErrDisabled indica que el endpoint se deshabilitó o eliminó después de encolar la entrega
+	ErrDisabled       = errors.New("webhook endpoint is disabled")
+	ErrInvalidHeader  = errors.New("invalid signature header")
+	ErrStaleTimestamp = errors.New("signature timestamp outside tolerance")
+	ErrNoMatch        = errors.New("no matching signature")
+)
+
+// Endpoint es una URL de un comercio suscrita a eventos (colección merchant_webhooks).
+// El secreto se guarda para poder firmar; al cliente solo se le entrega al crear o rotar.
+type Endpoint struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	MerchantID        primitive.ObjectID `json:"merchantId" bson:"merchantId"`
+	URL               string             `json:"url" bson:"url"`
+	Events            []string           `json:"events" bson:"events"`
+	Description       string             `json:"description,omitempty" bson:"description,omitempty"`
+	Disabled          bool               `json:"disabled" bson:"disabled"`
+	Secret            string             `json:"-" bson:"secret"`
+	PreviousSecret    string             `json:"-" bson:"previousSecret,omitempty"`
+	PreviousExpiresAt *time.Time         `json:"previous_secret_expires_at,omitempty" bson:"previousExpiresAt,omitempty"`
+	CreatedAt         time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt         time.Time          `json:"updatedAt" bson:"updatedAt"`
+	RotatedAt         *time.Time         `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+}
+
+// Subscribed indica si el endpoint recibe el evento
+func (e *Endpoint) Subscribed(event string) bool {
+	for _, ev := range e.Events {
+		if ev == event || ev == EventAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Secrets son los secretos con que se firma ahora: el actual y, durante la gracia de una rotación, el anterior
+func (e *Endpoint) Secrets(now time.Time) []string {
+	secrets := []string{e.Secret}
+	if e.PreviousSecret != "" && e.PreviousExpiresAt != nil && now.Before(*e.PreviousExpiresAt) {
+		secrets = append(secrets, e.PreviousSecret)
+	}
+	return secrets
+}
+
+// ValidateURL exige http(s) con host; fuera de desarrollo solo https
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid webhook url: %s", raw)
+	}
+	if u.Scheme != "https" && !config.C.IsDevelopment() {
+		return fmt.Errorf("webhook url must use https: %s", raw)
+	}
+	return nil
+}
+
+// ValidateEvents revisa que los eventos existan
+func ValidateEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	for _, ev := range events {
+		if ev != EventAll && !contains(Events, ev) {
+			return fmt.Errorf("unknown event: %s", ev)
+		}
+	}
+	return nil
+}
+
+// GenerateSecret crea un secreto whsec_<base64url de 32 bytes>
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign arma el valor de X-ValPago-Signature para el body con cada secreto
+func Sign(secrets []string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	parts := []string{"t=" + ts}
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+signature(secret, ts, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Verify comprueba una cabecera X-ValPago-Signature (lo que debe hacer el receptor)
+func Verify(header string, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrInvalidHeader
+		}
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrInvalidHeader
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrStaleTimestamp
+	}
+	expected := signature(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrNoMatch
+}
+
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Load busca un endpoint por ID
+func Load(ctx context.Context, id primitive.ObjectID) (*Endpoint, error) {
+	var e Endpoint
+	err := db.Mongo().Collection("merchant_webhooks").FindOne(ctx, bson.M{"_id": id}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ForEvent devuelve los endpoints activos del comercio suscritos al evento
+func ForEvent(ctx context.Context, merchantID primitive.ObjectID, event string) ([]Endpoint, error) {
+	cursor, err := db.Mongo().Collection("merchant_webhooks").Find(ctx, bson.M{
+		"merchantId": merchantID,
+		"disabled":   false,
+		"events":     bson.M{"$in": bson.A{event, EventAll}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoints := []Endpoint{}
+	if err := cursor.All(ctx, &endpoints); err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/streams"
+)
+
+// dlqFieldPrefix marca los campos que agrega la DLQ; se quitan al reprocesar la entrada
+const dlqFieldPrefix = "dlq_"
+
+var ErrDLQEntryNotFound = errors.New("dlq entry not found")
+
+// DLQEntry es una entrada del stream de procesamiento que agotó sus entregas
+type DLQEntry struct {
+	ID         string                 `json:"id"`
+	OriginalID string                 `json:"original_id"`
+	Reason     string                 `json:"reason"`
+	Consumer   string                 `json:"consumer"`
+	Deliveries int64                  `json:"deliveries"`
+	FailedAt   time.Time              `json:"failed_at"`
+	Values     map[string]interface{} `json:"values"`
+}
+
+// DLQStream es el stream de entradas fallidas: <REDIS_STREAM_NAMESPACE>:dlq
+func DLQStream() string {
+	return config.C.RedisStreamNS + ":dlq"
+}
+
+// moveToDLQ copia la entrada a la DLQ (con tope DLQ_MAXLEN) con el motivo y la confirma en el grupo, en una sola transacción
+func moveToDLQ(ctx context.Context, message redis.XMessage, consumer string, deliveries int64, reason string) error {
+	values := make(map[string]interface{}, len(message.Values)+5)
+	for k, v := range message.Values {
+		values[k] = v
+	}
+	values[dlqFieldPrefix+"original_id"] = message.ID
+	values[dlqFieldPrefix+"reason"] = reason
+	values[dlqFieldPrefix+"consumer"] = consumer
+	values[dlqFieldPrefix+"deliveries"] = deliveries
+	values[dlqFieldPrefix+"failed_at"] = time.Now().Unix()
+
+	_, err := db.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, streams.Args(DLQStream(), values))
+		pipe.XAck(ctx, config.C.RedisStreamNS, config.C.RedisGroup, message.ID)
+		return nil
+	})
+	return err
+}
+
+// ListDLQ devuelve las entradas más recientes primero; before continúa después de la última vista
+func ListDLQ(ctx context.Context, count int64, before string) ([]DLQEntry, error) {
+	end := "+"
+	if before != "" {
+		end = "(" + before
+	}
+	messages, err := db.Rdb.XRevRangeN(ctx, DLQStream(), end, "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]DLQEntry, 0, len(messages))
+	for _, m := range messages {
+		entries = append(entries, dlqEntry(m))
+	}
+	return entries, nil
+}
+
+// DLQLength es la cantidad de entradas en la DLQ
+func DLQLength(ctx context.Context) (int64, error) {
+	return db.Rdb.XLen(ctx, DLQStream()).Result()
+}
+
+// GetDLQ busca una entrada de la DLQ por ID
+func GetDLQ(ctx context.Context, id string) (*DLQEntry, error) {
+	messages, err := db.Rdb.XRange(ctx, DLQStream(), id, id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrDLQEntryNotFound
+	}
+	entry := dlqEntry(messages[0])
+	return &entry, nil
+}
+
+// ReplayDLQ vuelve a publicar la entrada original en el stream de procesamiento y la quita de la DLQ.
+// Devuelve el ID de la entrada nueva.
+func ReplayDLQ(ctx context.Context, id string) (string, error) {
+	entry, err := GetDLQ(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	var add *redis.StringCmd
+	_, err = db.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		add = pipe.XAdd(ctx, streams.Args(config.C.RedisStreamNS, entry.Values))
+		pipe.XDel(ctx, DLQStream(), id)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return add.Val(), nil
+}
+
+// DeleteDLQ descarta una entrada de la DLQ
+func DeleteDLQ(ctx context.Context, id string) error {
+	n, err := db.Rdb.XDel(ctx, DLQStream(), id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDLQEntryNotFound
+	}
+	return nil
+}
+
+// dlqEntry separa los campos de la DLQ de los valores originales
+func dlqEntry(m redis.XMessage) DLQEntry {
+	entry := DLQEntry{ID: m.ID, Values: map[string]interface{}{}}
+	for k, v := range m.Values {
+		if !strings.HasPrefix(k, dlqFieldPrefix) {
+			entry.Values[k] = v
+			continue
+		}
+		s := fmt.Sprintf("%v", v)
+		switch strings.TrimPrefix(k, dlqFieldPrefix) {
+		case "original_id":
+			entry.OriginalID = s
+		case "reason":
+			entry.Reason = s
+		case "consumer":
+			entry.Consumer = s
+		case "deliveries":
+			entry.Deliveries, _ = strconv.ParseInt(s, 10, 64)
+		case "failed_at":
+			if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+				entry.FailedAt = time.Unix(unix, 0).UTC()
+			}
+		}
+	}
+	return entry
+}
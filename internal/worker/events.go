@@ -0,0 +1,158 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/receipts"
+	"github.com/usuario/valpago-backend/internal/streams"
+)
+
+// Eventos del stream de procesamiento
+const (
+	EventCreated  = "transaction.created"
+	EventReview   = "transaction.review"
+	EventPending  = "transaction.pending"
+	EventApproved = "transaction.approved"
+	EventRejected = "transaction.rejected"
+	// Eventos que solo se publican hacia el front
+	EventDraft   = "transaction.draft"
+	EventReceipt = "transaction.receipt"
+)
+
+var ErrInvalidEvent = errors.New("invalid event")
+
+// Event es el sobre de una entrada del stream: type, data (la transacción como JSON) y timestamp
+type Event struct {
+	ID            string          // ID de la entrada en el stream
+	Type          string          `json:"type"`
+	Data          json.RawMessage `json:"data"`
+	Timestamp     int64           `json:"timestamp"`
+	TransactionID string          `json:"-"`
+}
+
+// transactionRef son los campos de data que el worker necesita en todos los eventos
+type transactionRef struct {
+	ID primitive.ObjectID `json:"_id"`
+}
+
+// ParseEvent valida la entrada y arma el sobre. Un error indica que reintentarla no sirve.
+func ParseEvent(message redis.XMessage) (Event, error) {
+	ev := Event{ID: message.ID}
+	t, ok := message.Values["type"].(string)
+	if !ok || t == "" {
+		return ev, fmt.Errorf("%w: missing type", ErrInvalidEvent)
+	}
+	ev.Type = t
+
+	data, ok := message.Values["data"].(string)
+	if !ok || !json.Valid([]byte(data)) {
+		return ev, fmt.Errorf("%w: %s without valid data", ErrInvalidEvent, t)
+	}
+	ev.Data = json.RawMessage(data)
+
+	var ref transactionRef
+	if err := json.Unmarshal(ev.Data, &ref); err == nil && !ref.ID.IsZero() {
+		ev.TransactionID = ref.ID.Hex()
+	}
+	if ts, ok := message.Values["timestamp"].(string); ok {
+		ev.Timestamp, _ = strconv.ParseInt(ts, 10, 64)
+	}
+	return ev, nil
+}
+
+// Handler procesa un evento; si devuelve error la entrada no se confirma y se reintenta
+type Handler func(ctx context.Context, ev Event) error
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]Handler{}
+)
+
+// Register asocia un handler a un tipo de evento (reemplaza el anterior si lo hubiera)
+func Register(eventType string, h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[eventType] = h
+}
+
+// handlerFor devuelve el handler del tipo; los eventos sin handler propio se reenvían tal cual al front
+func handlerFor(eventType string) Handler {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	if h, ok := handlers[eventType]; ok {
+		return h
+	}
+	return forwardEvent
+}
+
+func init() {
+	Register(EventCreated, handleCreated)
+	for _, t := range []string{EventReview, EventPending, EventApproved, EventRejected} {
+		Register(t, forwardEvent)
+	}
+}
+
+// handleCreated descarga el comprobante de la transacción nueva y después avisa al front (pending,
+// o draft si viene de WhatsApp, mientras siga en ese estado) y publica el resultado de la descarga. Es idempotente: si el
+// comprobante ya se descargó (reintento tras un fallo al confirmar) no vuelve a hacer nada.
+func handleCreated(ctx context.Context, ev Event) error {
+	var tx createdPayload
+	if err := json.Unmarshal(ev.Data, &tx); err != nil || tx.ID.IsZero() {
+		log.Printf("Invalid transaction.created payload in %s: %v", ev.ID, err)
+		return nil
+	}
+
+	result, err := fetchReceipt(ctx, tx)
+	if err != nil || result == nil {
+		return err
+	}
+
+	// Si el evento se reintentó después de que la transacción avanzó (review, approved...), el aviso
+	// de pending/draft llegaría fuera de orden: solo se publica si sigue en el estado del evento
+	status, err := currentStatus(ctx, tx.ID)
+	if err != nil {
+		return err
+	}
+	if status == tx.Status {
+		notification := EventPending
+		if tx.Status == "draft" {
+			notification = EventDraft
+		}
+		if err := publishNotification(ctx, notification, ev.Data); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Transaction %s moved from %s to %s, skipping its %s notification", tx.ID.Hex(), tx.Status, status, tx.Status)
+	}
+	payload, _ := json.Marshal(struct {
+		ID primitive.ObjectID `json:"_id"`
+		*receipts.Result
+	}{tx.ID, result})
+	return publishNotification(ctx, EventReceipt, payload)
+}
+
+// forwardEvent reenvía el cambio de estado al front con el mismo tipo
+func forwardEvent(ctx context.Context, ev Event) error {
+	return publishNotification(ctx, ev.Type, ev.Data)
+}
+
+// publishNotification publica el evento en el stream SSE
+func publishNotification(ctx context.Context, eventType string, data []byte) error {
+	return db.Rdb.XAdd(ctx, streams.Args(config.C.RedisNotificationsStream, map[string]interface{}{
+		"type":      eventType,
+		"data":      string(data),
+		"timestamp": time.Now().Unix(),
+	})).Err()
+}
@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// pool procesa eventos con un número fijo de goroutines. Cada transacción se asigna siempre a la
+// misma goroutine (por hash de su ID), así sus eventos se manejan en el orden del stream.
+//
+// Las entradas encoladas o en proceso se registran en inflight: recoverPending no las vuelve a
+// encolar, y keepAlive les renueva el idle en el grupo para que otro consumidor no las reclame
+// mientras esperan turno.
+type pool struct {
+	consumer string
+	shards   []chan Event
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+	stop     chan struct{}
+}
+
+// newPool arranca las goroutines; los handlers corren con ctx, que debe cancelarse al vencer el
+// plazo de apagado (no al recibir la señal) para terminar lo ya leído
+func newPool(ctx context.Context, consumer string, size, queue int) *pool {
+	if size < 1 {
+		size = 1
+	}
+	if queue < 1 {
+		queue = 1
+	}
+	p := &pool{
+		consumer: consumer,
+		shards:   make([]chan Event, size),
+		inflight: map[string]struct{}{},
+		stop:     make(chan struct{}),
+	}
+	for i := range p.shards {
+		ch := make(chan Event, queue)
+		p.shards[i] = ch
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for ev := range ch {
+				p.handle(ctx, ev)
+			}
+		}()
+	}
+	go p.keepAlive(ctx)
+	return p
+}
+
+// submit encola la entrada en la goroutine de su transacción; bloquea si esa cola está llena.
+// Las entradas que no se pueden interpretar pasan directo a la DLQ; las que ya están en el pool
+// (p. ej. reclamadas de nuevo mientras esperaban) se ignoran.
+func (p *pool) submit(ctx context.Context, message redis.XMessage, deliveries int64) {
+	ev, err := ParseEvent(message)
+	if err != nil {
+		log.Printf("Discarding stream entry %s: %v", message.ID, err)
+		if err := moveToDLQ(ctx, message, p.consumer, deliveries, err.Error()); err != nil {
+			log.Printf("Failed to move %s to DLQ: %v", message.ID, err)
+		}
+		return
+	}
+
+	p.mu.Lock()
+	if _, ok := p.inflight[ev.ID]; ok {
+		p.mu.Unlock()
+		return
+	}
+	p.inflight[ev.ID] = struct{}{}
+	p.mu.Unlock()
+
+	key := ev.TransactionID
+	if key == "" {
+		key = ev.ID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	select {
+	case p.shards[h.Sum32()%uint32(len(p.shards))] <- ev:
+	case <-ctx.Done():
+		p.done(ev.ID)
+	}
+}
+
+// close deja de aceptar eventos y espera a que se terminen los encolados
+func (p *pool) close() {
+	for _, ch := range p.shards {
+		close(ch)
+	}
+	p.wg.Wait()
+	close(p.stop)
+}
+
+func (p *pool) done(id string) {
+	p.mu.Lock()
+	delete(p.inflight, id)
+	p.mu.Unlock()
+}
+
+// keepAlive renueva con XCLAIM JUSTID (que no suma entregas) el idle de las entradas del pool,
+// a un tercio de WORKER_CLAIM_MIN_IDLE_SECONDS
+func (p *pool) keepAlive(ctx context.Context) {
+	interval := time.Duration(config.C.WorkerClaimMinIdleSeconds) * time.Second / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		ids := make([]string, 0, len(p.inflight))
+		for id := range p.inflight {
+			ids = append(ids, id)
+		}
+		p.mu.Unlock()
+		if len(ids) == 0 {
+			continue
+		}
+
+		if err := db.Rdb.XClaimJustID(ctx, &redis.XClaimArgs{
+			Stream:   config.C.RedisStreamNS,
+			Group:    config.C.RedisGroup,
+			Consumer: p.consumer,
+			Messages: ids,
+		}).Err(); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh %d in-flight entries: %v", len(ids), err)
+		}
+	}
+}
+
+// handle ejecuta el handler del evento y confirma la entrada solo si terminó bien; si no, queda
+// pendiente y recoverPending la reintenta (o la pasa a la DLQ al agotar las entregas)
+func (p *pool) handle(ctx context.Context, ev Event) {
+	defer p.done(ev.ID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Handler for %s (%s) panicked: %v", ev.Type, ev.ID, r)
+		}
+	}()
+
+	if err := handlerFor(ev.Type)(ctx, ev); err != nil {
+		log.Printf("Handling %s (%s) failed, will retry: %v", ev.Type, ev.ID, err)
+		return
+	}
+	if err := db.Rdb.XAck(ctx, config.C.RedisStreamNS, config.C.RedisGroup, ev.ID).Err(); err != nil {
+		log.Printf("Failed to ack %s: %v", ev.ID, err)
+	}
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/receipts"
+)
+
+// createdPayload son los campos de la transacción que necesita el worker en transaction.created
+type createdPayload struct {
+	ID         primitive.ObjectID `json:"_id"`
+	Status     string             `json:"status"`
+	SupportURL string             `json:"support_url"`
+}
+
+// fetchReceipt descarga el comprobante de una transacción recién creada. Devuelve nil sin error si
+// ya se había descargado, y error si no se pudo guardar el resultado, para que el evento se reintente.
+func fetchReceipt(ctx context.Context, tx createdPayload) (*receipts.Result, error) {
+	status, err := receipts.Status(ctx, tx.ID)
+	if err != nil {
+		return nil, fmt.Errorf("loading transaction %s: %w", tx.ID.Hex(), err)
+	}
+	if status == receipts.FetchOK {
+		log.Printf("Receipt for transaction %s already fetched, skipping", tx.ID.Hex())
+		return nil, nil
+	}
+
+	result, err := receipts.Process(ctx, tx.ID, tx.SupportURL)
+	if err != nil {
+		return nil, fmt.Errorf("receipt processing for transaction %s: %w", tx.ID.Hex(), err)
+	}
+	if result.Fetch.Status != receipts.FetchOK {
+		log.Printf("Receipt for transaction %s not available (%s): %s", tx.ID.Hex(), result.Fetch.Status, result.Fetch.Error)
+	}
+	return result, nil
+}
+
+// currentStatus lee el estado actual de la transacción, que puede haber avanzado desde que se publicó el evento
+func currentStatus(ctx context.Context, id primitive.ObjectID) (string, error) {
+	var tx struct {
+		Status string `bson:"status"`
+	}
+	err := db.Mongo().Collection("transactions").FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"status": 1}),
+	).Decode(&tx)
+	if err != nil {
+		return "", fmt.Errorf("loading transaction %s: %w", id.Hex(), err)
+	}
+	return tx.Status, nil
+}
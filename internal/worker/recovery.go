@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/usuario/valpago-backend/internal/config"
+	"github.com/usuario/valpago-backend/internal/db"
+)
+
+// claimBatch es cuántas entradas se reclaman por llamada a XAUTOCLAIM
+const claimBatch = 50
+
+// recoverPending reclama con XAUTOCLAIM las entradas que llevan más de WORKER_CLAIM_MIN_IDLE_SECONDS
+// sin confirmar (su consumidor murió a mitad del procesamiento o el handler falló) y las reprocesa. Las que ya se
+// entregaron más de WORKER_MAX_DELIVERIES veces pasan a <stream>:dlq. Al final elimina del grupo
+// los consumidores inactivos sin pendientes.
+func recoverPending(ctx context.Context, consumer string, workers *pool) {
+	stream, group := config.C.RedisStreamNS, config.C.RedisGroup
+	minIdle := time.Duration(config.C.WorkerClaimMinIdleSeconds) * time.Second
+
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := db.Rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  minIdle,
+			Start:    start,
+			Count:    claimBatch,
+		}).Result()
+		if err != nil {
+			log.Printf("XAUTOCLAIM on %s failed: %v", stream, err)
+			return
+		}
+
+		if len(messages) > 0 {
+			log.Printf("Reclaimed %d pending entries from %s", len(messages), stream)
+			resubmit(ctx, consumer, workers, messages)
+		}
+
+		// "0-0" indica que se recorrió toda la lista de pendientes
+		if next == "" || next == "0-0" {
+			break
+		}
+		start = next
+	}
+
+	cleanupConsumers(ctx, consumer)
+}
+
+// recoverOwn vuelve a procesar las entradas que este consumidor leyó y no confirmó (p. ej. el pod se
+// reinició a mitad de un lote). Con el ID "0" XREADGROUP devuelve el historial pendiente propio.
+func recoverOwn(ctx context.Context, consumer string, workers *pool) {
+	stream, group := config.C.RedisStreamNS, config.C.RedisGroup
+
+	start := "0"
+	for ctx.Err() == nil {
+		streams, err := db.Rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{stream, start},
+			Count:    claimBatch,
+			Block:    -1,
+		}).Result()
+		if err != nil {
+			if err != redis.Nil {
+				log.Printf("Reading pending entries of %s failed: %v", consumer, err)
+			}
+			return
+		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			return
+		}
+
+		messages := streams[0].Messages
+		log.Printf("Resuming %d pending entries of %s", len(messages), consumer)
+		resubmit(ctx, consumer, workers, messages)
+		start = messages[len(messages)-1].ID
+	}
+}
+
+// resubmit manda al pool entradas ya entregadas antes; las que agotaron sus entregas pasan a la DLQ
+func resubmit(ctx context.Context, consumer string, workers *pool, messages []redis.XMessage) {
+	deliveries := deliveryCounts(ctx, consumer, messages)
+	for _, message := range messages {
+		if len(message.Values) == 0 {
+			// La entrada se borró del stream (p. ej. por XTRIM) mientras estaba pendiente
+			db.Rdb.XAck(ctx, config.C.RedisStreamNS, config.C.RedisGroup, message.ID)
+			continue
+		}
+		if n := deliveries[message.ID]; n > int64(config.C.WorkerMaxDeliveries) {
+			if err := moveToDLQ(ctx, message, consumer, n, "max deliveries exceeded"); err != nil {
+				log.Printf("Failed to move %s to DLQ: %v", message.ID, err)
+			}
+			continue
+		}
+		workers.submit(ctx, message, deliveries[message.ID])
+	}
+}
+
+// deliveryCounts consulta cuántas veces se entregó cada entrada reclamada (XAUTOCLAIM ya sumó esta)
+func deliveryCounts(ctx context.Context, consumer string, messages []redis.XMessage) map[string]int64 {
+	counts := make(map[string]int64, len(messages))
+	pending, err := db.Rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   config.C.RedisStreamNS,
+		Group:    config.C.RedisGroup,
+		Start:    messages[0].ID,
+		End:      messages[len(messages)-1].ID,
+		Count:    int64(len(messages)) * 2,
+		Consumer: consumer,
+	}).Result()
+	if err != nil {
+		log.Printf("XPENDING on %s failed: %v", config.C.RedisStreamNS, err)
+		return counts
+	}
+	for _, p := range pending {
+		counts[p.ID] = p.RetryCount
+	}
+	return counts
+}
+
+// cleanupConsumers borra del grupo los consumidores sin pendientes que llevan más de
+// WORKER_CONSUMER_IDLE_MINUTES inactivos (los nombres que dejaron reinicios y pods anteriores)
+func cleanupConsumers(ctx context.Context, self string) {
+	stream, group := config.C.RedisStreamNS, config.C.RedisGroup
+	maxIdle := time.Duration(config.C.WorkerConsumerIdleMinutes) * time.Minute
+
+	consumers, err := db.Rdb.XInfoConsumers(ctx, stream, group).Result()
+	if err != nil {
+		log.Printf("XINFO CONSUMERS on %s failed: %v", stream, err)
+		return
+	}
+	for _, c := range consumers {
+		if c.Name == self || c.Pending > 0 || c.Idle < maxIdle {
+			continue
+		}
+		if err := db.Rdb.XGroupDelConsumer(ctx, stream, group, c.Name).Err(); err != nil {
+			log.Printf("Failed to delete idle consumer %s: %v", c.Name, err)
+			continue
+		}
+		log.Printf("Deleted idle consumer %s from %s (idle %s)", c.Name, group, c.Idle.Round(time.Second))
+	}
+}
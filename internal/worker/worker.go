@@ -4,16 +4,38 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 
 	"github.com/usuario/valpago-backend/internal/config"
 	"github.com/usuario/valpago-backend/internal/db"
+	"github.com/usuario/valpago-backend/internal/streams"
 )
 
-func Start() {
+// ConsumerName es el nombre del consumidor en el grupo: REDIS_CONSUMER_NAME-<POD_NAME o hostname>.
+// Es estable entre reinicios para retomar los pendientes propios y no dejar consumidores huérfanos;
+// dos procesos en el mismo host necesitan POD_NAME distintos.
+func ConsumerName() string {
+	instance := config.C.PodName
+	if instance == "" {
+		if host, err := os.Hostname(); err == nil {
+			instance = host
+		}
+	}
+	if instance == "" {
+		return fmt.Sprintf("%s-%d", config.C.RedisConsumer, time.Now().Unix())
+	}
+	return config.C.RedisConsumer + "-" + instance
+}
+
+// Start consume el stream de procesamiento hasta que se cancele ctx. Al cancelarse deja de leer,
+// termina el lote en curso y vuelve cuando los handlers acabaron. Los handlers corren con drain,
+// que se cancela al vencer el plazo de apagado: lo que no terminó queda pendiente y se reintenta.
+func Start(ctx, drain context.Context) {
 	if db.Rdb == nil {
 		log.Println("Redis not available, skipping worker...")
 		return
@@ -21,10 +43,10 @@ func Start() {
 
 	log.Println("Starting Redis worker...")
 
-	ctx := context.Background()
 	groupName := config.C.RedisGroup
 	streamName := config.C.RedisStreamNS
-	consumerName := fmt.Sprintf("%s-%d", config.C.RedisConsumer, time.Now().Unix())
+	consumerName := ConsumerName()
+	log.Printf("Consuming %s as %s", streamName, consumerName)
 
 	// Create consumer group if it doesn't exist
 	err := db.Rdb.XGroupCreateMkStream(ctx, streamName, groupName, "0").Err()
@@ -32,9 +54,36 @@ func Start() {
 		log.Printf("Error creating consumer group %s for stream %s: %v", groupName, streamName, err)
 	}
 
-	for {
+	workers := newPool(drain, consumerName, config.C.WorkerConcurrency, config.C.WorkerQueueSize)
+	// Archivado y recorte del stream (ver streams.Archive); se detiene junto con el worker
+	var archiver sync.WaitGroup
+	archiver.Add(1)
+	go func() {
+		defer archiver.Done()
+		streams.RunArchiver(ctx)
+	}()
+
+	defer func() {
+		workers.close()
+		archiver.Wait()
+		log.Println("Redis worker stopped")
+	}()
+
+	// Al arrancar se retoman las entradas que este consumidor dejó sin confirmar antes de reiniciarse
+	// y luego las que dejaron pendientes otros consumidores caídos
+	recoverOwn(ctx, consumerName, workers)
+	recoverPending(ctx, consumerName, workers)
+	lastRecovery := time.Now()
+	recoveryInterval := time.Duration(config.C.WorkerClaimIntervalSeconds) * time.Second
+
+	for ctx.Err() == nil {
+		if time.Since(lastRecovery) >= recoveryInterval {
+			recoverPending(ctx, consumerName, workers)
+			lastRecovery = time.Now()
+		}
+
 		// Read from Redis stream
-		streams, err := db.Rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		read, err := db.Rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    groupName,
 			Consumer: consumerName,
 			Streams:  []string{streamName, ">"},
@@ -43,216 +92,27 @@ func Start() {
 		}).Result()
 
 		if err != nil {
-			if err == redis.Nil {
+			if err == redis.Nil || ctx.Err() != nil {
 				// No messages, continue
 				continue
 			}
 			log.Printf("Redis stream error: %v", err)
-			time.Sleep(time.Second * 5)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 5):
+			}
 			continue
 		}
 
-		for _, stream := range streams {
+		// Cada entrada se confirma cuando su handler termina bien (ver pool.handle)
+		for _, stream := range read {
 			for _, message := range stream.Messages {
-				// Process transaction
-				processTransaction(ctx, message)
-
-				// Acknowledge message
-				db.Rdb.XAck(ctx, streamName, groupName, message.ID)
+				workers.submit(drain, message, 1)
 			}
 		}
 	}
 }
 
-func processTransaction(ctx context.Context, message redis.XMessage) {
-	log.Printf("Processing transaction: %s", message.ID)
-
-	// Simulate processing time
-	// time.Sleep(time.Second * 2)
-
-	// Notificar al front solo los PENDING (para que aparezcan en la cola)
-	if raw, ok := message.Values["data"]; ok {
-		originalJSON := fmt.Sprintf("%v", raw)
-
-		/*originalJSON, err := normalizeIdToUnderscoreId(originalJSON)
-		if err != nil {
-			log.Printf("Error normalizing ID: %v", err)
-			return
-		}*/
-
-		notification := map[string]interface{}{
-			"type":      "transaction.pending",
-			"data":      string(originalJSON),
-			"timestamp": time.Now().Unix(),
-		}
-		db.Rdb.XAdd(ctx, &redis.XAddArgs{
-			Stream: config.C.RedisNotificationsStream,
-			Values: notification,
-		})
-
-		return
-	}
-
-	// Manejar otros tipos de eventos (review, approved, rejected)
-	if eventType, ok := message.Values["type"]; ok {
-		eventTypeStr := fmt.Sprintf("%v", eventType)
-		payload := ""
-		if raw, hasData := message.Values["data"]; hasData {
-			payload = fmt.Sprintf("%v", raw)
-		}
-
-		// Notificar al front según el tipo de evento
-		notification := map[string]interface{}{
-			"type":      eventTypeStr,
-			"data":      payload,
-			"timestamp": time.Now().Unix(),
-		}
-		db.Rdb.XAdd(ctx, &redis.XAddArgs{
-			Stream: config.C.RedisNotificationsStream,
-			Values: notification,
-		})
-		return
-	}
-
-	// Fallback: publicar evento mínimo si no hay "data"
-	notificationData := map[string]interface{}{
-		"status":    "review",
-		"timestamp": time.Now().Unix(),
-	}
-	db.Rdb.XAdd(ctx, &redis.XAddArgs{
-		Stream: config.C.RedisNotificationsStream,
-		Values: notificationData,
-	})
-}
-
-// fetchAndUploadSupportImage intenta descargar la imagen desde Meta con Bearer y subirla a Supabase; si falla, usa imagen local
-/*func fetchAndUploadSupportImage(ctx context.Context, transactionJSON string) (string, error) {
-// Extraer support_url del JSON (búsqueda simple para evitar dependencia de structs)
-// Se asume que el campo es: "support_url":"<url>"
-idx := strings.Index(transactionJSON, "\"support_url\":\"")
-if idx == -1 {
-	return "", fmt.Errorf("support_url not found in payload")
-}
-start := idx + len("\"support_url\":\"")
-end := strings.Index(transactionJSON[start:], "\"")
-if end == -1 {
-	return "", fmt.Errorf("malformed support_url in payload")
-}
-supportURL := transactionJSON[start : start+end]
-
-// Obtener URL real de la imagen desde Graph API
-realImageURL, err := getRealImageURLFromMeta(ctx, supportURL)
-if err != nil {
-	log.Printf("Error getting real image URL from Meta: %v", err)
-	return fallbackUpload()
-}
-
-// Descargar imagen real con Bearer
-req, err := http.NewRequestWithContext(ctx, http.MethodGet, realImageURL, nil)
-if err != nil {
-	log.Println(err, "error NewRequestWithContext descargando imagen")
-	return fallbackUpload()
-}
-if config.C.BearerTokenMeta != "" {
-	req.Header.Set("Authorization", "Bearer "+config.C.BearerTokenMeta)
-}
-
-resp, err := http.DefaultClient.Do(req)
-if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
-	if resp != nil {
-		resp.Body.Close()
-	}
-	log.Println(err, "error DefaultClient.Do imagen")
-	log.Println(resp.StatusCode, "status code imagen")
-	log.Println(resp.Status, "status imagen")
-	return fallbackUpload()
-}
-defer resp.Body.Close()
-
-// Asegurar que es imagen
-contentType := resp.Header.Get("Content-Type")
-
-if contentType == "" {
-	// intentar detectar por algunos bytes
-	peek := make([]byte, 512)
-	n, _ := io.ReadFull(resp.Body, peek)
-	contentType = http.DetectContentType(peek[:n])
-	resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(peek[:n]), resp.Body))
-}
-if !strings.HasPrefix(contentType, "image/") {
-	log.Println(contentType, "content type imagen no es image/")
-	return fallbackUpload()
-}
-
-data, err := io.ReadAll(resp.Body)
-if err != nil {
-	log.Println(err, "error ReadAll imagen")
-	return fallbackUpload()
-}
-
-// Nombre y extensión
-ext := ".jpeg"
-if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
-	ext = exts[0]
-}
-fileName := fmt.Sprintf("evidence_%d%s", time.Now().UnixNano(), ext)
-log.Println(fileName, "fileName imagen")
-
-// Subir a Supabase
-/*url, err := uploadToSupabase(ctx, fileName, data, contentType)
-if err != nil {
-	log.Println(err, "error uploadToSupabase imagen")
-	return fallbackUpload()
-}
-log.Println(url, "url fetchAndUploadSupportImage imagen")*/
-// url := fmt.Sprintf("data:%s;base64,%s", "image/jpeg", base64.StdEncoding.EncodeToString(data))
-// return url, nil
-// }*/
-
-/*func fallbackUpload() (string, error) {
-	// Ruta relativa: internal/worker/img/Comprobante-test.jpeg
-	wd, _ := os.Getwd()
-	path := filepath.Join(wd, "internal", "worker", "img", "Comprobante-test.jpeg")
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	// uploadToSupabase(ctx, fmt.Sprintf("fallback_%d.jpeg", time.Now().UnixNano()), data, "image/jpeg")
-	url := fmt.Sprintf("data:%s;base64,%s", "image/jpeg", base64.StdEncoding.EncodeToString(data))
-	return url, err
-}*/
-
-/*func uploadToSupabase(ctx context.Context, name string, data []byte, contentType string) (string, error) {
-	if config.C.SupabaseURLProject == "" || config.C.SupabaseBucket == "" || config.C.SupabaseAPIKey == "" {
-		return "", fmt.Errorf("supabase env missing")
-	}
-	// Endpoint de Storage: POST /storage/v1/object/{bucket}/{path}
-	url := fmt.Sprintf("%s/storage/v1/object/%s/%s", strings.TrimRight(config.C.SupabaseURLProject, "/"), config.C.SupabaseBucket, name)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "Bearer "+config.C.SupabaseAPIKey)
-	req.Header.Set("apikey", config.C.SupabaseAPIKey)
-	req.Header.Set("Content-Type", contentType)
-	// Preferir URL pública
-	req.Header.Set("x-upsert", "true")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("supabase upload failed: %s", string(body))
-	}
-
-	// Construir URL pública: {project}/storage/v1/object/public/{bucket}/{name}
-	publicURL := fmt.Sprintf("%s/storage/v1/object/public/%s/%s", strings.TrimRight(config.C.SupabaseURLProject, "/"), config.C.SupabaseBucket, name)
-	return publicURL, nil
-}*/
-
 // normalizeIdToUnderscoreId convierte el campo "id" a "_id" en el JSON
 /*func normalizeIdToUnderscoreId(jsonStr string) (string, error) {
 	var txDoc map[string]interface{}
@@ -348,44 +208,3 @@ log.Println(url, "url fetchAndUploadSupportImage imagen")*/
 	}
 	return cleanURL, nil
 }*/
-
-// getRealImageURLFromMeta obtiene la URL real de la imagen desde Graph API de Meta
-/*func getRealImageURLFromMeta(ctx context.Context, mid string) (string, error) {
-	// Construir URL de Graph API
-	graphURL := fmt.Sprintf("https://graph.facebook.com/v18.0/%s", mid)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, graphURL, nil)
-
-	if err != nil {
-		return "[graph otro ERROR]", err
-	}
-
-	if config.C.BearerTokenMeta != "" {
-		req.Header.Set("Authorization", "Bearer "+config.C.BearerTokenMeta)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "[graph.facebook ERROR]", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("Graph API request failed with status: %d", resp.StatusCode)
-	}
-
-	// Parsear respuesta JSON
-	var response struct {
-		URL string `json:"url"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
-	}
-
-	if response.URL == "" {
-		return "", fmt.Errorf("empty URL in Graph API response")
-	}
-
-	return response.URL, nil
-}*/